@@ -0,0 +1,199 @@
+package taibai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Login types supported by Login
+const (
+	LoginTypePassword = "m.login.password"
+	LoginTypeToken    = "m.login.token"
+)
+
+// AuthAPI handles authentication and session management
+type AuthAPI struct {
+	client *Client
+}
+
+// LoginRequest represents a request to log in
+type LoginRequest struct {
+	// Type is the login type (LoginTypePassword or LoginTypeToken). If
+	// empty it is inferred from whether Token is set.
+	Type string `json:"type"`
+
+	// Identifier identifies the user for m.login.password
+	Identifier *UserIdentifier `json:"identifier,omitempty"`
+
+	// Password is the password for m.login.password
+	Password string `json:"password,omitempty"`
+
+	// Token is the login token for m.login.token
+	Token string `json:"token,omitempty"`
+
+	// DeviceID reuses an existing device instead of creating a new one
+	DeviceID string `json:"device_id,omitempty"`
+
+	// InitialDeviceDisplayName is the display name of a newly created device
+	InitialDeviceDisplayName string `json:"initial_device_display_name,omitempty"`
+}
+
+// LoginResponse represents the response from logging in
+type LoginResponse struct {
+	// AccessToken is the token for authenticating further requests
+	AccessToken string `json:"access_token"`
+
+	// DeviceID is the device the token belongs to
+	DeviceID string `json:"device_id"`
+
+	// UserID is the fully-qualified ID of the logged in user
+	UserID string `json:"user_id"`
+}
+
+// Login authenticates with a password or login token and, on success, sets
+// the client's token to the returned access token
+func (a *AuthAPI) Login(ctx context.Context, req *LoginRequest) (*LoginResponse, error) {
+	if req == nil {
+		return nil, fmt.Errorf("login request is required")
+	}
+
+	body := *req
+	if body.Type == "" {
+		body.Type = LoginTypePassword
+		if body.Token != "" {
+			body.Type = LoginTypeToken
+		}
+	}
+
+	switch body.Type {
+	case LoginTypePassword:
+		if body.Identifier == nil || body.Password == "" {
+			return nil, fmt.Errorf("%s requires an identifier and password", LoginTypePassword)
+		}
+	case LoginTypeToken:
+		if body.Token == "" {
+			return nil, fmt.Errorf("%s requires a token", LoginTypeToken)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported login type %q", body.Type)
+	}
+
+	result := &LoginResponse{}
+	err := a.client.POST(ctx, a.client.apiPrefix()+"/login", &body, result)
+	if err != nil {
+		return nil, err
+	}
+
+	a.client.SetToken(result.AccessToken)
+	return result, nil
+}
+
+// AuthData is the auth object used for user-interactive authentication
+type AuthData struct {
+	// Type is the login type (e.g., "m.login.password")
+	Type string `json:"type"`
+
+	// Session is the session ID returned by the server's 401 challenge
+	Session string `json:"session,omitempty"`
+
+	// Identifier identifies the user being authenticated
+	Identifier *UserIdentifier `json:"identifier,omitempty"`
+
+	// Password is the password for m.login.password
+	Password string `json:"password,omitempty"`
+}
+
+// UserIdentifier identifies a user for authentication
+type UserIdentifier struct {
+	// Type is the identifier type (e.g., "m.id.user")
+	Type string `json:"type"`
+
+	// User is the user ID or localpart
+	User string `json:"user,omitempty"`
+}
+
+// WhoAmIResponse identifies the owner of the access token
+type WhoAmIResponse struct {
+	// UserID is the user the token belongs to
+	UserID string `json:"user_id"`
+
+	// DeviceID is the device the token belongs to, if any
+	DeviceID string `json:"device_id,omitempty"`
+}
+
+// WhoAmI returns the user and device of the current access token
+func (a *AuthAPI) WhoAmI(ctx context.Context) (*WhoAmIResponse, error) {
+	result := &WhoAmIResponse{}
+	err := a.client.GET(ctx, a.client.apiPrefix()+"/account/whoami", nil, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Device represents a device (session) of the authenticated user
+type Device struct {
+	// DeviceID is the identifier of the device
+	DeviceID string `json:"device_id"`
+
+	// DisplayName is the display name of the device
+	DisplayName string `json:"display_name,omitempty"`
+
+	// LastSeenIP is the last IP the device was seen from
+	LastSeenIP string `json:"last_seen_ip,omitempty"`
+
+	// LastSeenTS is the last time the device was seen, in milliseconds
+	LastSeenTS int64 `json:"last_seen_ts,omitempty"`
+}
+
+// DevicesResponse represents the response from listing devices
+type DevicesResponse struct {
+	// Devices is the list of devices
+	Devices []Device `json:"devices"`
+}
+
+// GetDevices lists the devices (sessions) of the authenticated user
+func (a *AuthAPI) GetDevices(ctx context.Context) (*DevicesResponse, error) {
+	result := &DevicesResponse{}
+	err := a.client.GET(ctx, a.client.apiPrefix()+"/devices", nil, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RevokeDevice deletes another device, invalidating its access token.
+// The current session's token is left untouched.
+func (a *AuthAPI) RevokeDevice(ctx context.Context, deviceID string, auth *AuthData) error {
+	body := map[string]interface{}{}
+	if auth != nil {
+		body["auth"] = auth
+	}
+
+	return a.client.doJSON(ctx, &Request{
+		Method: http.MethodDelete,
+		Path:   a.client.apiPrefix() + "/devices/" + pathEscape(deviceID),
+		Body:   body,
+	}, nil)
+}
+
+// Logout invalidates the current access token and clears the client's
+// token on success
+func (a *AuthAPI) Logout(ctx context.Context) error {
+	if err := a.client.POST(ctx, a.client.apiPrefix()+"/logout", nil, nil); err != nil {
+		return err
+	}
+	a.client.SetToken("")
+	return nil
+}
+
+// LogoutAll invalidates every access token of the user, including the
+// current one, and clears the client's token on success
+func (a *AuthAPI) LogoutAll(ctx context.Context) error {
+	if err := a.client.POST(ctx, a.client.apiPrefix()+"/logout/all", nil, nil); err != nil {
+		return err
+	}
+	a.client.SetToken("")
+	return nil
+}
@@ -0,0 +1,287 @@
+package taibai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestLogoutAll(t *testing.T) {
+	var path string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	if err := client.Auth.LogoutAll(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/logout/all" {
+		t.Errorf("Expected path '/_matrix/client/r0/logout/all', got '%s'", path)
+	}
+
+	if client.GetToken() != "" {
+		t.Errorf("Expected token to be cleared, got '%s'", client.GetToken())
+	}
+}
+
+func TestRevokeDevice(t *testing.T) {
+	var method, path string
+	var body struct {
+		Auth *AuthData `json:"auth"`
+	}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		method = req.Method
+		path = req.URL.Path
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	err := client.Auth.RevokeDevice(context.Background(), "OTHERDEVICE", &AuthData{
+		Type:       "m.login.password",
+		Session:    "uia-session",
+		Identifier: &UserIdentifier{Type: "m.id.user", User: "@bot:localhost"},
+		Password:   "secret",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if method != http.MethodDelete {
+		t.Errorf("Expected DELETE, got %s", method)
+	}
+	if path != "/_matrix/client/r0/devices/OTHERDEVICE" {
+		t.Errorf("Expected path '/_matrix/client/r0/devices/OTHERDEVICE', got '%s'", path)
+	}
+	if body.Auth == nil || body.Auth.Session != "uia-session" || body.Auth.Type != "m.login.password" {
+		t.Errorf("Expected UIA auth in body, got %+v", body.Auth)
+	}
+
+	// Revoking another device must not touch the current session
+	if client.GetToken() != "test-token" {
+		t.Errorf("Expected token to be preserved, got '%s'", client.GetToken())
+	}
+}
+
+func TestRevokeDeviceEscapesID(t *testing.T) {
+	var path, query string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.EscapedPath()
+		query = req.URL.RawQuery
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	if err := client.Auth.RevokeDevice(context.Background(), "a/b?c", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/devices/a%2Fb%3Fc" {
+		t.Errorf("Expected escaped device ID in path, got '%s'", path)
+	}
+	if query != "" {
+		t.Errorf("Expected no query string, got '%s'", query)
+	}
+}
+
+func TestRevokeDeviceUIAChallenge(t *testing.T) {
+	mock := &MockHTTPClient{
+		Response: newMockResponse(401, map[string]interface{}{
+			"session": "uia-session",
+			"flows":   []interface{}{map[string]interface{}{"stages": []string{"m.login.password"}}},
+		}),
+	}
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	err := client.Auth.RevokeDevice(context.Background(), "OTHERDEVICE", nil)
+	if err == nil {
+		t.Fatal("Expected error for UIA challenge")
+	}
+
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Code != 401 {
+		t.Errorf("Expected 401 APIError, got %v", err)
+	}
+}
+
+func TestLoginPassword(t *testing.T) {
+	var path string
+	var body LoginRequest
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{
+			"access_token": "new-token",
+			"device_id":    "DEVICE",
+			"user_id":      "@bot:localhost",
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	resp, err := client.Auth.Login(context.Background(), &LoginRequest{
+		Identifier: &UserIdentifier{Type: "m.id.user", User: "bot"},
+		Password:   "secret",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/login" {
+		t.Errorf("Expected path '/_matrix/client/r0/login', got '%s'", path)
+	}
+	if body.Type != LoginTypePassword {
+		t.Errorf("Expected type '%s', got '%s'", LoginTypePassword, body.Type)
+	}
+	if body.Identifier == nil || body.Identifier.User != "bot" || body.Password != "secret" {
+		t.Errorf("Expected credentials in body, got %+v", body)
+	}
+	if resp.DeviceID != "DEVICE" || resp.UserID != "@bot:localhost" {
+		t.Errorf("Expected device 'DEVICE' and user '@bot:localhost', got %+v", resp)
+	}
+	if client.GetToken() != "new-token" {
+		t.Errorf("Expected token 'new-token', got '%s'", client.GetToken())
+	}
+}
+
+func TestLoginToken(t *testing.T) {
+	var body LoginRequest
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{
+			"access_token": "new-token",
+			"device_id":    "DEVICE",
+			"user_id":      "@bot:localhost",
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	_, err := client.Auth.Login(context.Background(), &LoginRequest{Token: "login-token"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if body.Type != LoginTypeToken || body.Token != "login-token" {
+		t.Errorf("Expected m.login.token with token 'login-token', got %+v", body)
+	}
+	if client.GetToken() != "new-token" {
+		t.Errorf("Expected token 'new-token', got '%s'", client.GetToken())
+	}
+}
+
+func TestLoginForbidden(t *testing.T) {
+	mock := &MockHTTPClient{
+		Response: newMockResponse(403, map[string]string{
+			"errcode": "M_FORBIDDEN",
+			"error":   "Invalid password",
+		}),
+	}
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "old-token",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	_, err := client.Auth.Login(context.Background(), &LoginRequest{
+		Identifier: &UserIdentifier{Type: "m.id.user", User: "bot"},
+		Password:   "wrong",
+	})
+	if err == nil {
+		t.Fatal("Expected error for forbidden login")
+	}
+
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Code != 403 || apiErr.Message != "Invalid password" {
+		t.Errorf("Expected 403 APIError 'Invalid password', got %v", err)
+	}
+	if client.GetToken() != "old-token" {
+		t.Errorf("Expected token to be preserved, got '%s'", client.GetToken())
+	}
+}
+
+func TestLogout(t *testing.T) {
+	var path string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	if err := client.Auth.Logout(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/logout" {
+		t.Errorf("Expected path '/_matrix/client/r0/logout', got '%s'", path)
+	}
+	if client.GetToken() != "" {
+		t.Errorf("Expected token to be cleared, got '%s'", client.GetToken())
+	}
+}
+
+func TestLogoutFailurePreservesToken(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		return newMockResponse(500, map[string]string{"error": "internal error"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Auth = &AuthAPI{client: client}
+
+	if err := client.Auth.Logout(context.Background()); err == nil {
+		t.Error("Expected error from Logout")
+	}
+	if err := client.Auth.LogoutAll(context.Background()); err == nil {
+		t.Error("Expected error from LogoutAll")
+	}
+
+	if client.GetToken() != "test-token" {
+		t.Errorf("Expected token to be preserved, got '%s'", client.GetToken())
+	}
+}
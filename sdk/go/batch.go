@@ -0,0 +1,133 @@
+package taibai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// batchWorkers is the number of concurrent requests of batch operations
+	batchWorkers = 4
+
+	// batchRateLimitRetries bounds how often one item is retried after
+	// M_LIMIT_EXCEEDED before its error is reported
+	batchRateLimitRetries = 5
+
+	// defaultRateLimitBackoff is used when a 429 carries no retry_after_ms
+	defaultRateLimitBackoff = time.Second
+)
+
+// InviteUsers invites every user to the room. The returned slice holds the
+// error of each invite, aligned with userIDs (nil on success).
+func (r *RoomAPI) InviteUsers(ctx context.Context, roomID string, userIDs []string) []error {
+	return runBatch(ctx, len(userIDs), func(ctx context.Context, i int) error {
+		return r.InviteUser(ctx, roomID, &InviteUserRequest{UserID: userIDs[i]})
+	})
+}
+
+// SendTextMessages sends the same text message to every room. The returned
+// slice holds the error of each send, aligned with roomIDs (nil on success).
+func (m *MessageAPI) SendTextMessages(ctx context.Context, roomIDs []string, content string) []error {
+	return runBatch(ctx, len(roomIDs), func(ctx context.Context, i int) error {
+		_, err := m.SendTextMessage(ctx, roomIDs[i], content)
+		return err
+	})
+}
+
+// runBatch runs fn for the items 0..n-1 on a small worker pool. When an item
+// is rate limited the whole pool pauses for the server's retry_after_ms and
+// the item is retried, so one 429 does not fail the rest of the batch.
+func runBatch(ctx context.Context, n int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+	gate := &rateLimitGate{}
+
+	jobs := make(chan int, n)
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	workers := batchWorkers
+	if n < workers {
+		workers = n
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = runBatchItem(ctx, gate, i, fn)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// runBatchItem runs a single item, waiting out and retrying rate limits
+func runBatchItem(ctx context.Context, gate *rateLimitGate, i int, fn func(ctx context.Context, i int) error) error {
+	for attempt := 0; ; attempt++ {
+		if err := gate.wait(ctx); err != nil {
+			return err
+		}
+
+		err := fn(ctx, i)
+		if err == nil || !IsRateLimited(err) || attempt >= batchRateLimitRetries {
+			return err
+		}
+		gate.pause(rateLimitBackoff(err))
+	}
+}
+
+// rateLimitBackoff returns the retry_after_ms hint of a rate-limit error
+func rateLimitBackoff(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfterMs > 0 {
+		return time.Duration(apiErr.RetryAfterMs) * time.Millisecond
+	}
+	return defaultRateLimitBackoff
+}
+
+// rateLimitGate holds back the workers of a batch until a rate limit expires
+type rateLimitGate struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// pause blocks the gate for d, extending any pause already in effect
+func (g *rateLimitGate) pause(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until := time.Now().Add(d); until.After(g.until) {
+		g.until = until
+	}
+}
+
+// wait blocks until the gate is open or the context ends
+func (g *rateLimitGate) wait(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		g.mu.Lock()
+		delay := time.Until(g.until)
+		g.mu.Unlock()
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package taibai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInviteUsersRateLimited(t *testing.T) {
+	userIDs := []string{
+		"@u1:localhost", "@u2:localhost", "@u3:localhost",
+		"@u4:localhost", "@u5:localhost", "@u6:localhost",
+	}
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	var limitedAt time.Time
+	var afterLimit []time.Time
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		var body InviteUserRequest
+		json.NewDecoder(req.Body).Decode(&body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[body.UserID]++
+		if body.UserID == "@u3:localhost" && attempts[body.UserID] == 1 {
+			limitedAt = time.Now()
+			return newMockResponse(429, map[string]interface{}{
+				"errcode":        "M_LIMIT_EXCEEDED",
+				"error":          "Too many requests",
+				"retry_after_ms": 100,
+			}), nil
+		}
+		if !limitedAt.IsZero() {
+			afterLimit = append(afterLimit, time.Now())
+		}
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	errs := client.Room.InviteUsers(context.Background(), "!room:localhost", userIDs)
+
+	if len(errs) != len(userIDs) {
+		t.Fatalf("Expected %d results, got %d", len(userIDs), len(errs))
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Expected invite of %s to succeed, got %v", userIDs[i], err)
+		}
+	}
+	for _, userID := range userIDs {
+		if attempts[userID] == 0 {
+			t.Errorf("Expected %s to be invited", userID)
+		}
+	}
+	if attempts["@u3:localhost"] != 2 {
+		t.Errorf("Expected rate-limited invite to be retried once, got %d attempts", attempts["@u3:localhost"])
+	}
+
+	// The retry of the rate-limited item waits out retry_after_ms
+	last := afterLimit[len(afterLimit)-1]
+	if last.Sub(limitedAt) < 100*time.Millisecond {
+		t.Errorf("Expected the batch to pause for retry_after_ms, resumed after %v", last.Sub(limitedAt))
+	}
+}
+
+func TestSendTextMessagesReportsFailures(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/_matrix/client/r0/rooms/!forbidden:localhost/send/m.room.message" {
+			return newMockResponse(403, map[string]string{"errcode": "M_FORBIDDEN", "error": "Not in room"}), nil
+		}
+		return newMockResponse(200, map[string]string{"event_id": "$event"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	errs := client.Message.SendTextMessages(context.Background(), []string{
+		"!a:localhost", "!forbidden:localhost", "!b:localhost",
+	}, "hello")
+
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("Expected sends to other rooms to succeed, got %v", errs)
+	}
+	if !IsForbidden(errs[1]) {
+		t.Errorf("Expected M_FORBIDDEN for the second room, got %v", errs[1])
+	}
+}
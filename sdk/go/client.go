@@ -3,12 +3,21 @@ package taibai
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // HTTPClient interface for making HTTP requests
@@ -22,12 +31,26 @@ type Client struct {
 	httpClient HTTPClient
 	baseURL    string
 	token      string
+	tokenMu    sync.RWMutex // guards token, which may be rotated mid-flight
+
+	// inflight tracks the requests of a SafeClient-wrapped client so that
+	// SafeClient.Close can cancel and wait for them
+	inflight *requestTracker
 
 	// APIs
 	Message *MessageAPI
 	Room    *RoomAPI
 	User    *UserAPI
 	Approval *ApprovalAPI
+	Auth     *AuthAPI
+	Sync     *SyncAPI
+	Media    *MediaAPI
+
+	// Latest server-reported rate limit, see RateLimitStatus
+	rateLimitMu        sync.Mutex
+	rateLimitKnown     bool
+	rateLimitRemaining int
+	rateLimitReset     time.Time
 }
 
 // NewClient creates a new Taibai client
@@ -52,6 +75,29 @@ func NewClient(config *Config) (*Client, error) {
 		MaxIdleConnsPerHost: config.MaxIdleConnections,
 	}
 
+	// Route through the configured proxy, or the environment's
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, &ConfigError{fmt.Sprintf("invalid proxy URL: %v", err)}
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	// Apply TLS settings; the caller's config is cloned, not mutated
+	if config.TLSConfig != nil || config.InsecureSkipVerify {
+		tlsConfig := &tls.Config{}
+		if config.TLSConfig != nil {
+			tlsConfig = config.TLSConfig.Clone()
+		}
+		if config.InsecureSkipVerify {
+			tlsConfig.InsecureSkipVerify = true
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
 	httpClient := &http.Client{
 		Timeout:   config.Timeout,
 		Transport: transport,
@@ -65,10 +111,13 @@ func NewClient(config *Config) (*Client, error) {
 	}
 
 	// Initialize APIs
-	client.Message = &MessageAPI{client: client}
+	client.Message = &MessageAPI{client: client, ordered: config.OrderedRoomSends}
 	client.Room = &RoomAPI{client: client}
 	client.User = &UserAPI{client: client}
 	client.Approval = &ApprovalAPI{client: client}
+	client.Auth = &AuthAPI{client: client}
+	client.Sync = &SyncAPI{client: client}
+	client.Media = &MediaAPI{client: client, cacheSize: config.MediaUploadCacheSize}
 
 	return client, nil
 }
@@ -77,9 +126,31 @@ func NewClient(config *Config) (*Client, error) {
 type Request struct {
 	Method  string
 	Path    string
+
+	// Body is marshaled as JSON, unless it is an io.Reader which is streamed
+	// as-is (set the Content-Type header accordingly)
 	Body    interface{}
 	Query   map[string]string
 	Headers map[string]string
+
+	// QueryValues holds additional, possibly repeated, query parameters
+	// such as server_name hints
+	QueryValues url.Values
+
+	// Timeout overrides the client's timeout for this request (optional).
+	// Long-polling endpoints such as /sync use it to outlive Config.Timeout.
+	Timeout time.Duration
+
+	// Idempotent declares the request safe to repeat, making it eligible for
+	// retries on retryable status codes regardless of its method. Requests
+	// with idempotent methods (GET, HEAD, PUT, DELETE, OPTIONS) are always
+	// eligible.
+	Idempotent bool
+
+	// Stream leaves a successful response body unread in Response.Stream
+	// instead of buffering it into Response.Body. The caller must close it.
+	// Error responses are still read and decoded.
+	Stream bool
 }
 
 // Response represents an API response
@@ -87,6 +158,24 @@ type Response struct {
 	StatusCode int
 	Body       []byte
 	Headers    http.Header
+
+	// Stream is the unread body of a successful Request.Stream request
+	Stream io.ReadCloser
+}
+
+// closeHook is a ReadCloser that runs fn once when it is closed, releasing
+// the context a streamed response body depends on
+type closeHook struct {
+	io.ReadCloser
+	once sync.Once
+	fn   func()
+}
+
+// Close closes the underlying body and runs the hook
+func (h *closeHook) Close() error {
+	err := h.ReadCloser.Close()
+	h.once.Do(h.fn)
+	return err
 }
 
 // ErrorResponse represents an error response from the API
@@ -94,6 +183,12 @@ type ErrorResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	ErrorMsg string `json:"error,omitempty"`
+
+	// Errcode is the Matrix error code (e.g., "M_FORBIDDEN")
+	Errcode string `json:"errcode,omitempty"`
+
+	// RetryAfterMs is the rate-limit backoff hint for M_LIMIT_EXCEEDED
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
 }
 
 func (e *ErrorResponse) Error() string {
@@ -106,35 +201,131 @@ func (e *ErrorResponse) Error() string {
 	return "unknown error"
 }
 
-// do performs an HTTP request
+// do performs an HTTP request through the configured interceptors. The
+// first interceptor is the outermost one.
 func (c *Client) do(ctx context.Context, req *Request) (*Response, error) {
+	var release context.CancelFunc
+	if c.inflight != nil {
+		var err error
+		ctx, release, err = c.inflight.begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer c.inflight.end()
+	}
+
+	next := c.doRequest
+	if c.config != nil {
+		for i := len(c.config.Interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := c.config.Interceptors[i], next
+			next = func(ctx context.Context, req *Request) (*Response, error) {
+				return interceptor(ctx, req, inner)
+			}
+		}
+	}
+
+	resp, err := next(ctx, req)
+	if release != nil {
+		// A streamed body keeps the request context until it is closed
+		if resp != nil && resp.Stream != nil {
+			resp.Stream = &closeHook{ReadCloser: resp.Stream, fn: release}
+		} else {
+			release()
+		}
+	}
+	return resp, err
+}
+
+// doRequest performs an HTTP request, retrying transient failures according
+// to the configured retry policy
+func (c *Client) doRequest(ctx context.Context, req *Request) (*Response, error) {
 	// Build URL
-	url := c.baseURL + req.Path
+	fullURL := joinURL(c.baseURL, req.Path)
 
 	// Add query parameters
-	if len(req.Query) > 0 {
-		query := ""
+	if len(req.Query) > 0 || len(req.QueryValues) > 0 {
+		query := url.Values{}
 		for key, value := range req.Query {
-			if query != "" {
-				query += "&"
+			query.Set(key, value)
+		}
+		for key, values := range req.QueryValues {
+			for _, value := range values {
+				query.Add(key, value)
 			}
-			query += fmt.Sprintf("%s=%s", key, value)
 		}
-		url += "?" + query
+		fullURL += "?" + query.Encode()
 	}
 
-	// Marshal body
-	var bodyReader io.Reader
-	if req.Body != nil {
-		bodyBytes, err := json.Marshal(req.Body)
+	// Streaming bodies are sent as-is and cannot be replayed on retries
+	stream, isStream := req.Body.(io.Reader)
+
+	// Marshal body once so it can be replayed on retries
+	var bodyBytes []byte
+	if req.Body != nil && !isStream {
+		var err error
+		bodyBytes, err = json.Marshal(req.Body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		bodyReader = bytes.NewReader(bodyBytes)
+	}
+
+	if c.logBodies(req) && bodyBytes != nil {
+		c.logger().Debugf("taibai: --> %s %s body=%s", req.Method, req.Path, c.formatBody(bodyBytes))
+	}
+
+	maxRetries := 0
+	if c.config != nil && !isStream {
+		maxRetries = c.config.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		bodyReader := stream
+		if bodyBytes != nil {
+			bodyReader = bytes.NewReader(bodyBytes)
+		}
+
+		resp, err := c.doOnce(ctx, req, fullURL, bodyReader)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= maxRetries || !c.shouldRetry(req, resp, err) {
+			return nil, err
+		}
+		if !c.waitRetry(ctx, attempt) {
+			return nil, err
+		}
+	}
+}
+
+// doOnce performs a single HTTP attempt. On HTTP errors the returned
+// Response carries the status code alongside the error.
+func (c *Client) doOnce(ctx context.Context, req *Request, fullURL string, bodyReader io.Reader) (*Response, error) {
+	httpClient := c.httpClient
+
+	// A streamed body outlives this call; its Close releases the timeout
+	cancelTimeout := func() {}
+	streaming := false
+	defer func() {
+		if !streaming {
+			cancelTimeout()
+		}
+	}()
+
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		cancelTimeout = cancel
+
+		// The context now bounds the request; lift a shorter client timeout
+		if hc, ok := httpClient.(*http.Client); ok && hc.Timeout > 0 && hc.Timeout < req.Timeout {
+			override := *hc
+			override.Timeout = req.Timeout
+			httpClient = &override
+		}
 	}
 
 	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, req.Method, url, bodyReader)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, fullURL, bodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -143,21 +334,56 @@ func (c *Client) do(ctx context.Context, req *Request) (*Response, error) {
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Accept", "application/json")
 
+	userAgent := DefaultUserAgent
+	if c.config != nil && c.config.UserAgent != "" {
+		userAgent = c.config.UserAgent
+	}
+	httpReq.Header.Set("User-Agent", userAgent)
+
+	if c.config != nil {
+		for key, value := range c.config.DefaultHeaders {
+			httpReq.Header.Set(key, value)
+		}
+	}
+
 	// Add authentication token
-	if c.token != "" {
-		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	if token := c.GetToken(); token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
 	}
 
-	// Add custom headers
+	// Add custom headers; these may override the JSON defaults above
 	for key, value := range req.Headers {
 		httpReq.Header.Set(key, value)
 	}
 
+	logger := c.logger()
+	logger.Debugf("taibai: --> %s %s headers=%v", req.Method, req.Path, redactHeaders(httpReq.Header))
+
 	// Perform request
-	resp, err := c.httpClient.Do(httpReq)
+	start := time.Now()
+	resp, err := httpClient.Do(httpReq)
 	if err != nil {
+		logger.Errorf("taibai: <-- %s %s failed after %s: %v", req.Method, req.Path, time.Since(start), err)
+		c.observe(req, 0, time.Since(start))
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
+	c.observe(req, resp.StatusCode, time.Since(start))
+	c.recordRateLimit(resp.Header)
+
+	if resp.StatusCode >= 400 {
+		logger.Errorf("taibai: <-- %s %s %d (%s)", req.Method, req.Path, resp.StatusCode, time.Since(start))
+	} else {
+		logger.Debugf("taibai: <-- %s %s %d (%s)", req.Method, req.Path, resp.StatusCode, time.Since(start))
+	}
+
+	if req.Stream && resp.StatusCode < 400 {
+		streaming = true
+		return &Response{
+			StatusCode: resp.StatusCode,
+			Headers:    resp.Header,
+			Stream:     &closeHook{ReadCloser: resp.Body, fn: cancelTimeout},
+		}, nil
+	}
 	defer resp.Body.Close()
 
 	// Read response body
@@ -166,20 +392,217 @@ func (c *Client) do(ctx context.Context, req *Request) (*Response, error) {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	if contentType := resp.Header.Get("Content-Type"); c.logBodies(req) && len(respBody) > 0 &&
+		(contentType == "" || strings.Contains(contentType, "json")) {
+		logger.Debugf("taibai: <-- %s %s body=%s", req.Method, req.Path, c.formatBody(respBody))
+	}
+
+	result := &Response{
+		StatusCode: resp.StatusCode,
+		Body:       respBody,
+		Headers:    resp.Header,
+	}
+
 	// Check for API error
 	if resp.StatusCode >= 400 {
 		var errResp ErrorResponse
 		if err := json.Unmarshal(respBody, &errResp); err != nil {
-			return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
+			return result, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
+		}
+		return result, &APIError{
+			Code:         resp.StatusCode,
+			Message:      errResp.Error(),
+			Errcode:      errResp.Errcode,
+			RetryAfterMs: errResp.RetryAfterMs,
 		}
-		return nil, &APIError{Code: resp.StatusCode, Message: errResp.Error()}
 	}
 
-	return &Response{
-		StatusCode: resp.StatusCode,
-		Body:       respBody,
-		Headers:    resp.Header,
-	}, nil
+	return result, nil
+}
+
+// logger returns the configured logger or a no-op one
+func (c *Client) logger() Logger {
+	if c.config != nil && c.config.Logger != nil {
+		return c.config.Logger
+	}
+	return noopLogger{}
+}
+
+// observe reports a finished attempt to the configured metrics collector
+func (c *Client) observe(req *Request, status int, dur time.Duration) {
+	if c.config != nil && c.config.Metrics != nil {
+		c.config.Metrics.ObserveRequest(req.Method, req.Path, status, dur)
+	}
+}
+
+// recordRateLimit stores the X-RateLimit-Remaining/X-RateLimit-Reset headers
+// of a response, if present. The reset value is accepted either as a Unix
+// timestamp or as a number of seconds from now.
+func (c *Client) recordRateLimit(header http.Header) {
+	remainingHeader := header.Get("X-RateLimit-Remaining")
+	if remainingHeader == "" {
+		return
+	}
+	remaining, err := strconv.Atoi(strings.TrimSpace(remainingHeader))
+	if err != nil {
+		return
+	}
+
+	var reset time.Time
+	if seconds, err := strconv.ParseInt(strings.TrimSpace(header.Get("X-RateLimit-Reset")), 10, 64); err == nil {
+		if seconds > 1000000000 {
+			reset = time.Unix(seconds, 0)
+		} else {
+			reset = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+	}
+
+	c.rateLimitMu.Lock()
+	c.rateLimitKnown = true
+	c.rateLimitRemaining = remaining
+	c.rateLimitReset = reset
+	c.rateLimitMu.Unlock()
+}
+
+// RateLimitStatus returns the rate limit reported by the most recent response
+// that carried X-RateLimit-* headers. ok is false until such a response has
+// been seen; reset is zero if the server did not send X-RateLimit-Reset.
+func (c *Client) RateLimitStatus() (remaining int, reset time.Time, ok bool) {
+	c.rateLimitMu.Lock()
+	defer c.rateLimitMu.Unlock()
+	return c.rateLimitRemaining, c.rateLimitReset, c.rateLimitKnown
+}
+
+// logBodies reports whether the bodies of req should be logged. Media
+// transfers are excluded, as are streamed uploads.
+func (c *Client) logBodies(req *Request) bool {
+	if c.config == nil || !c.config.LogBodies {
+		return false
+	}
+	if _, isStream := req.Body.(io.Reader); isStream {
+		return false
+	}
+	return !strings.HasPrefix(req.Path, mediaPrefix)
+}
+
+// secretFieldPattern matches JSON string fields that carry credentials
+var secretFieldPattern = regexp.MustCompile(`"(access_token|refresh_token|token|password|new_password)"(\s*):(\s*)"(?:[^"\\]|\\.)*"`)
+
+// formatBody redacts credentials in a JSON body and truncates it to the
+// configured limit, backing up to a rune boundary so multi-byte characters
+// are not split
+func (c *Client) formatBody(body []byte) string {
+	redacted := secretFieldPattern.ReplaceAllString(string(body), `"$1"$2:$3"REDACTED"`)
+
+	limit := c.config.LogBodyLimit
+	if limit <= 0 {
+		limit = DefaultLogBodyLimit
+	}
+	if len(redacted) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(redacted[cut]) {
+			cut--
+		}
+		return fmt.Sprintf("%s... (%d bytes truncated)", redacted[:cut], len(redacted)-cut)
+	}
+	return redacted
+}
+
+// redactHeaders returns a copy of the headers with credentials masked
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	if redacted.Get("Authorization") != "" {
+		redacted.Set("Authorization", "REDACTED")
+	}
+	return redacted
+}
+
+// shouldRetry reports whether a failed attempt may be retried. Idempotent
+// requests are retried on retryable status codes and transport errors;
+// others only when the connection could not be established, so the
+// request never reached the server.
+func (c *Client) shouldRetry(req *Request, resp *Response, err error) bool {
+	idempotent := req.Idempotent || isIdempotentMethod(req.Method)
+	if resp == nil {
+		if idempotent {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		}
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	}
+
+	if !idempotent {
+		return false
+	}
+	for _, code := range c.retryableStatusCodes() {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableStatusCodes returns the configured retryable codes or the defaults
+func (c *Client) retryableStatusCodes() []int {
+	if c.config != nil && len(c.config.RetryableStatusCodes) > 0 {
+		return c.config.RetryableStatusCodes
+	}
+	return DefaultRetryableStatusCodes
+}
+
+// waitRetry sleeps for the backoff of the given attempt. It returns false if
+// the context ends first or its deadline leaves no room for another attempt.
+func (c *Client) waitRetry(ctx context.Context, attempt int) bool {
+	delay := c.retryDelay(attempt)
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retryDelay returns the backoff of the given attempt: the base delay
+// doubled per attempt up to the configured maximum, with jitter in
+// [delay/2, delay]
+func (c *Client) retryDelay(attempt int) time.Duration {
+	base := 500 * time.Millisecond
+	maxDelay := DefaultRetryMaxDelay
+	if c.config != nil {
+		if c.config.RetryBaseDelay > 0 {
+			base = c.config.RetryBaseDelay
+		}
+		if c.config.RetryMaxDelay > 0 {
+			maxDelay = c.config.RetryMaxDelay
+		}
+	}
+
+	// Double step by step so a large attempt cannot overflow the shift
+	delay := base
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// isIdempotentMethod reports whether the HTTP method is safe to repeat
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
 }
 
 // doJSON performs an HTTP request and unmarshals the response
@@ -191,13 +614,25 @@ func (c *Client) doJSON(ctx context.Context, req *Request, result interface{}) e
 
 	if result != nil && len(resp.Body) > 0 {
 		if err := json.Unmarshal(resp.Body, result); err != nil {
-			return fmt.Errorf("failed to unmarshal response: %w", err)
+			return &DecodeError{
+				StatusCode:  resp.StatusCode,
+				ContentType: resp.Headers.Get("Content-Type"),
+				Body:        resp.Body,
+				Err:         err,
+			}
 		}
 	}
 
 	return nil
 }
 
+// Call performs an arbitrary request and unmarshals the JSON response into
+// result (optional). It follows the client's retry policy; set
+// req.Idempotent to let non-idempotent methods be retried as well.
+func (c *Client) Call(ctx context.Context, req *Request, result interface{}) error {
+	return c.doJSON(ctx, req, result)
+}
+
 // GET performs a GET request
 func (c *Client) GET(ctx context.Context, path string, query map[string]string, result interface{}) error {
 	return c.doJSON(ctx, &Request{
@@ -234,13 +669,62 @@ func (c *Client) DELETE(ctx context.Context, path string, query map[string]strin
 	}, result)
 }
 
+// apiPrefix returns the path prefix of client-server endpoints for the
+// configured API version
+func (c *Client) apiPrefix() string {
+	version := DefaultAPIVersion
+	if c.config != nil && c.config.APIVersion != "" {
+		version = c.config.APIVersion
+	}
+	return "/_matrix/client/" + version
+}
+
+// joinURL appends path to baseURL with exactly one slash between them and
+// collapses duplicate slashes in the path, so a base URL with a path prefix
+// never yields "//" segments. The "//" after the scheme, escaped slashes
+// (%2F) inside segments and anything after a '?' are left untouched.
+func joinURL(baseURL, path string) string {
+	scheme := ""
+	if i := strings.Index(baseURL, "://"); i >= 0 {
+		scheme, baseURL = baseURL[:i+3], baseURL[i+3:]
+	}
+
+	joined := baseURL + "/" + path
+	query := ""
+	if i := strings.IndexByte(joined, '?'); i >= 0 {
+		joined, query = joined[:i], joined[i:]
+	}
+
+	var b strings.Builder
+	b.Grow(len(scheme) + len(joined) + len(query))
+	b.WriteString(scheme)
+	for i := 0; i < len(joined); i++ {
+		if joined[i] == '/' && i > 0 && joined[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(joined[i])
+	}
+	b.WriteString(query)
+	return b.String()
+}
+
+// pathEscape escapes a value for use as a single URL path segment, so IDs
+// and aliases containing '/', '#' or spaces survive routing
+func pathEscape(segment string) string {
+	return url.PathEscape(segment)
+}
+
 // SetToken sets the authentication token
 func (c *Client) SetToken(token string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
 	c.token = token
 }
 
 // GetToken gets the current authentication token
 func (c *Client) GetToken() string {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
 	return c.token
 }
 
@@ -252,10 +736,70 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// CloseIdleConnections releases the idle keep-alive connections of the
+// default HTTP client. It is a no-op for user-supplied HTTPClients.
+func (c *Client) CloseIdleConnections() {
+	if hc, ok := c.httpClient.(*http.Client); ok {
+		hc.CloseIdleConnections()
+	}
+}
+
 // SafeClient is a thread-safe wrapper around Client
 type SafeClient struct {
 	client *Client
-	mu     sync.RWMutex
+}
+
+// requestTracker counts in-flight requests without holding a lock across
+// network I/O. close refuses new requests, cancels the running ones and
+// waits for them to return.
+type requestTracker struct {
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newRequestTracker creates an open requestTracker
+func newRequestTracker() *requestTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &requestTracker{ctx: ctx, cancel: cancel}
+}
+
+// begin registers a request and returns a context that is also cancelled by
+// close. The caller must call end when the request has returned and release
+// once the context is no longer needed, which for a streamed response is
+// when its body is closed.
+func (t *requestTracker) begin(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, nil, fmt.Errorf("client is closed")
+	}
+	t.wg.Add(1)
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(t.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}, nil
+}
+
+// end marks a request begun with begin as returned
+func (t *requestTracker) end() {
+	t.wg.Done()
+}
+
+// close refuses new requests, cancels the in-flight ones and waits for them
+func (t *requestTracker) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+
+	t.cancel()
+	t.wg.Wait()
 }
 
 // NewSafeClient creates a new thread-safe Taibai client
@@ -264,26 +808,76 @@ func NewSafeClient(config *Config) (*SafeClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &SafeClient{client: client}, nil
+	return newSafeClient(client), nil
+}
+
+// newSafeClient wraps client so that all of its requests, including those of
+// the typed APIs, are tracked and cancelled by Close
+func newSafeClient(client *Client) *SafeClient {
+	sc := &SafeClient{client: client}
+	client.inflight = newRequestTracker()
+	return sc
 }
 
 // Do performs a thread-safe request
 func (sc *SafeClient) Do(ctx context.Context, req *Request) (*Response, error) {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
 	return sc.client.do(ctx, req)
 }
 
-// SetToken sets the authentication token thread-safely
+// SetToken sets the authentication token thread-safely. It does not wait for
+// in-flight requests; those already sent keep the token they were sent with.
 func (sc *SafeClient) SetToken(token string) {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
-	sc.client.token = token
+	sc.client.SetToken(token)
+}
+
+// GetToken gets the current authentication token thread-safely
+func (sc *SafeClient) GetToken() string {
+	return sc.client.GetToken()
+}
+
+// Message returns the message API. Like the other typed APIs its requests
+// are tracked by the SafeClient and are safe to use while SetToken rotates
+// the token.
+func (sc *SafeClient) Message() *MessageAPI {
+	return sc.client.Message
+}
+
+// Room returns the room API
+func (sc *SafeClient) Room() *RoomAPI {
+	return sc.client.Room
 }
 
-// Close closes the client thread-safely
+// User returns the user API
+func (sc *SafeClient) User() *UserAPI {
+	return sc.client.User
+}
+
+// Approval returns the approval API
+func (sc *SafeClient) Approval() *ApprovalAPI {
+	return sc.client.Approval
+}
+
+// Auth returns the auth API
+func (sc *SafeClient) Auth() *AuthAPI {
+	return sc.client.Auth
+}
+
+// Sync returns the sync API
+func (sc *SafeClient) Sync() *SyncAPI {
+	return sc.client.Sync
+}
+
+// Media returns the media API
+func (sc *SafeClient) Media() *MediaAPI {
+	return sc.client.Media
+}
+
+// Close closes the client thread-safely. Later requests fail, in-flight
+// requests (including long-polling syncs) are cancelled, and Close returns
+// once they have finished.
 func (sc *SafeClient) Close() error {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
+	if sc.client.inflight != nil {
+		sc.client.inflight.close()
+	}
 	return sc.client.Close()
 }
@@ -3,11 +3,18 @@ package taibai
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 // MockHTTPClient is a mock implementation of HTTPClient for testing
@@ -23,6 +30,13 @@ func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return m.Response, nil
 }
 
+// MockHTTPFunc adapts a function to HTTPClient so tests can inspect requests
+type MockHTTPFunc func(req *http.Request) (*http.Response, error)
+
+func (f MockHTTPFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 // Create mock response
 func newMockResponse(status int, body interface{}) *http.Response {
 	var bodyBytes []byte
@@ -211,6 +225,110 @@ func TestClientSafeClient(t *testing.T) {
 	safeClient.SetToken("new-token")
 }
 
+func TestSafeClientTokenRotation(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if auth := req.Header.Get("Authorization"); !strings.HasPrefix(auth, "Bearer token-") {
+			t.Errorf("Expected rotated bearer token, got '%s'", auth)
+		}
+		return newMockResponse(200, map[string]interface{}{"event_id": "$event"}), nil
+	})
+
+	client := &Client{httpClient: mock, baseURL: "http://localhost:8008", token: "token-0"}
+	client.Message = &MessageAPI{client: client}
+	safeClient := newSafeClient(client)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				_, err := safeClient.Message().SendMessage(context.Background(), &SendMessageRequest{
+					RoomID:      "!room:localhost",
+					Content:     "hello",
+					MessageType: "m.text",
+				})
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 50; i++ {
+			safeClient.SetToken(fmt.Sprintf("token-%d", i))
+		}
+	}()
+	wg.Wait()
+
+	if safeClient.GetToken() != "token-50" {
+		t.Errorf("Expected token 'token-50', got '%s'", safeClient.GetToken())
+	}
+}
+
+func TestSafeClientCloseCancelsAPICalls(t *testing.T) {
+	started := make(chan struct{})
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		// Behave like a long-poll that only ends when the request is cancelled
+		close(started)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+
+	client := &Client{httpClient: mock, baseURL: "http://localhost:8008", token: "test-token"}
+	client.Message = &MessageAPI{client: client}
+	safeClient := newSafeClient(client)
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := safeClient.Message().SendMessage(context.Background(), &SendMessageRequest{
+			RoomID:      "!room:localhost",
+			Content:     "hello",
+			MessageType: "m.text",
+		})
+		sent <- err
+	}()
+	<-started
+
+	// Token rotation does not wait for the in-flight send
+	rotated := make(chan struct{})
+	go func() {
+		safeClient.SetToken("rotated")
+		close(rotated)
+	}()
+	select {
+	case <-rotated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected SetToken not to wait for the in-flight send")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		safeClient.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Close to cancel the in-flight send")
+	}
+
+	select {
+	case err := <-sent:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the in-flight send to be cancelled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the in-flight send to return")
+	}
+
+	if _, err := safeClient.Message().SendTextMessage(context.Background(), "!room:localhost", "late"); err == nil {
+		t.Error("Expected requests after Close to fail")
+	}
+}
+
 func TestClientClose(t *testing.T) {
 	config := &Config{
 		ServerAddress: "localhost:8008",
@@ -226,6 +344,81 @@ func TestClientClose(t *testing.T) {
 	}
 }
 
+func TestNewClientTLSConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.ServerAddress = "https://localhost:8448"
+	config.TLSConfig = &tls.Config{ServerName: "matrix.example.org", MinVersion: tls.VersionTLS12}
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	transport := client.httpClient.(*http.Client).Transport.(*http.Transport)
+	if transport.TLSClientConfig == nil {
+		t.Fatal("Expected transport to use the TLS config")
+	}
+	if transport.TLSClientConfig.ServerName != "matrix.example.org" || transport.TLSClientConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected custom TLS settings, got %+v", transport.TLSClientConfig)
+	}
+
+	config = DefaultConfig()
+	config.ServerAddress = "https://localhost:8448"
+	config.InsecureSkipVerify = true
+
+	client, err = NewClient(config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	transport = client.httpClient.(*http.Client).Transport.(*http.Transport)
+	if transport.TLSClientConfig == nil || !transport.TLSClientConfig.InsecureSkipVerify {
+		t.Error("Expected InsecureSkipVerify to be applied to the transport")
+	}
+}
+
+func TestNewClientProxyURL(t *testing.T) {
+	config := DefaultConfig()
+	config.ServerAddress = "localhost:8008"
+	config.ProxyURL = "http://proxy.internal:3128"
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	transport := client.httpClient.(*http.Client).Transport.(*http.Transport)
+	req, _ := http.NewRequest("GET", "http://localhost:8008/_matrix/client/r0/sync", nil)
+	proxyURL, err := transport.Proxy(req)
+	if err != nil {
+		t.Fatalf("Expected no error from Proxy, got %v", err)
+	}
+	if proxyURL == nil || proxyURL.String() != "http://proxy.internal:3128" {
+		t.Errorf("Expected proxy 'http://proxy.internal:3128', got %v", proxyURL)
+	}
+
+	config.ProxyURL = "://bad"
+	if _, err := NewClient(config); err == nil {
+		t.Error("Expected error for invalid proxy URL")
+	}
+}
+
+func TestClientCloseIdleConnections(t *testing.T) {
+	config := DefaultConfig()
+	config.ServerAddress = "localhost:8008"
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Must not panic for the default client nor for user-supplied ones
+	client.CloseIdleConnections()
+
+	client.httpClient = &MockHTTPClient{}
+	client.CloseIdleConnections()
+}
+
 func TestErrorResponse(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -278,6 +471,71 @@ func TestAPIError(t *testing.T) {
 	}
 }
 
+func TestClientDoMatrixError(t *testing.T) {
+	mock := &MockHTTPClient{
+		Response: &http.Response{
+			StatusCode: 429,
+			Body: io.NopCloser(bytes.NewBufferString(
+				`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":2000}`,
+			)),
+		},
+	}
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+
+	_, err := client.do(context.Background(), &Request{
+		Method: "POST",
+		Path:   "/test",
+	})
+	if err == nil {
+		t.Fatal("Expected error for rate-limited response")
+	}
+
+	var matrixErr *MatrixError
+	if !errors.As(err, &matrixErr) {
+		t.Fatalf("Expected MatrixError, got %T", err)
+	}
+	if matrixErr.Errcode != ErrcodeLimitExceeded {
+		t.Errorf("Expected errcode '%s', got '%s'", ErrcodeLimitExceeded, matrixErr.Errcode)
+	}
+	if matrixErr.Message != "Too many requests" {
+		t.Errorf("Expected message 'Too many requests', got '%s'", matrixErr.Message)
+	}
+	if matrixErr.RetryAfterMs != 2000 {
+		t.Errorf("Expected retry_after_ms 2000, got %d", matrixErr.RetryAfterMs)
+	}
+
+	if Errcode(err) != ErrcodeLimitExceeded {
+		t.Errorf("Expected Errcode '%s', got '%s'", ErrcodeLimitExceeded, Errcode(err))
+	}
+	if !IsRateLimited(err) {
+		t.Error("Expected IsRateLimited to be true")
+	}
+	if IsNotFound(err) {
+		t.Error("Expected IsNotFound to be false")
+	}
+}
+
+func TestMatrixErrorHelpers(t *testing.T) {
+	wrapped := fmt.Errorf("lookup failed: %w", &APIError{Code: 404, Errcode: ErrcodeNotFound})
+	if !IsNotFound(wrapped) {
+		t.Error("Expected IsNotFound for wrapped M_NOT_FOUND")
+	}
+	if !IsForbidden(&APIError{Code: 403}) {
+		t.Error("Expected IsForbidden for bare 403")
+	}
+	if Errcode(errors.New("plain")) != "" {
+		t.Error("Expected empty Errcode for non-API error")
+	}
+	if IsRateLimited(nil) {
+		t.Error("Expected IsRateLimited to be false for nil")
+	}
+}
+
 func TestConfigError(t *testing.T) {
 	err := &ConfigError{
 		msg: "test error",
@@ -340,6 +598,35 @@ func TestClientDoBuildURL(t *testing.T) {
 	}
 }
 
+func TestClientDoJoinsPaths(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		path    string
+		want    string
+	}{
+		{"http://localhost:8008", "/_matrix/client/r0/sync", "http://localhost:8008/_matrix/client/r0/sync"},
+		{"http://localhost:8008/taibai/", "/_matrix/client/r0/sync", "http://localhost:8008/taibai/_matrix/client/r0/sync"},
+		{"http://localhost:8008/taibai", "_matrix//client/r0/sync", "http://localhost:8008/taibai/_matrix/client/r0/sync"},
+		{"https://example.com//api/", "//rooms/" + pathEscape("a/b") + "/state", "https://example.com/api/rooms/a%2Fb/state"},
+	}
+
+	for _, tt := range tests {
+		var got string
+		mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+			got = req.URL.String()
+			return newMockResponse(200, nil), nil
+		})
+		client := &Client{httpClient: mock, baseURL: tt.baseURL}
+
+		if _, err := client.do(context.Background(), &Request{Method: "GET", Path: tt.path}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Expected URL '%s' for %s + %s, got '%s'", tt.want, tt.baseURL, tt.path, got)
+		}
+	}
+}
+
 func TestClientDoWithBody(t *testing.T) {
 	mock := &MockHTTPClient{
 		Response: newMockResponse(200, nil),
@@ -571,3 +858,543 @@ func TestClientRequestHeaders(t *testing.T) {
 		t.Errorf("Expected no error, got %v", err)
 	}
 }
+
+func TestClientUserAgentAndDefaultHeaders(t *testing.T) {
+	var header http.Header
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		header = req.Header
+		return newMockResponse(200, nil), nil
+	})
+
+	client := &Client{
+		config: &Config{
+			UserAgent: "my-bot/1.0",
+			DefaultHeaders: map[string]string{
+				"X-Tenant":   "acme",
+				"X-Trace-ID": "default",
+			},
+		},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+
+	_, err := client.do(context.Background(), &Request{
+		Method:  "GET",
+		Path:    "/test",
+		Headers: map[string]string{"X-Trace-ID": "per-request"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if header.Get("User-Agent") != "my-bot/1.0" {
+		t.Errorf("Expected User-Agent 'my-bot/1.0', got '%s'", header.Get("User-Agent"))
+	}
+	if header.Get("X-Tenant") != "acme" {
+		t.Errorf("Expected default header X-Tenant 'acme', got '%s'", header.Get("X-Tenant"))
+	}
+	if header.Get("X-Trace-ID") != "per-request" {
+		t.Errorf("Expected per-request header to win, got '%s'", header.Get("X-Trace-ID"))
+	}
+
+	// Without configuration the SDK identifies itself
+	client.config = nil
+	client.do(context.Background(), &Request{Method: "GET", Path: "/test"})
+	if header.Get("User-Agent") != DefaultUserAgent {
+		t.Errorf("Expected User-Agent '%s', got '%s'", DefaultUserAgent, header.Get("User-Agent"))
+	}
+}
+
+// captureLogger records every log line for assertions
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *captureLogger) Errorf(format string, args ...interface{}) {
+	l.Debugf(format, args...)
+}
+
+func TestClientLogger(t *testing.T) {
+	logger := &captureLogger{}
+	client := &Client{
+		config:     &Config{Logger: logger},
+		httpClient: &MockHTTPClient{Response: newMockResponse(404, map[string]string{"error": "missing"})},
+		baseURL:    "http://localhost:8008",
+		token:      "secret-token",
+	}
+
+	client.do(context.Background(), &Request{Method: "GET", Path: "/test"})
+
+	output := strings.Join(logger.lines, "\n")
+	if !strings.Contains(output, "GET /test") {
+		t.Errorf("Expected request to be logged, got:\n%s", output)
+	}
+	if !strings.Contains(output, "404") {
+		t.Errorf("Expected status to be logged, got:\n%s", output)
+	}
+	if strings.Contains(output, "secret-token") {
+		t.Errorf("Expected token to be redacted, got:\n%s", output)
+	}
+}
+
+func TestClientInterceptors(t *testing.T) {
+	var header string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		header = req.Header.Get("X-Trace-ID")
+		return newMockResponse(200, nil), nil
+	})
+
+	var order []string
+	calls := 0
+	client := &Client{
+		config: &Config{
+			Interceptors: []Interceptor{
+				func(ctx context.Context, req *Request, next func(context.Context, *Request) (*Response, error)) (*Response, error) {
+					order = append(order, "trace")
+					if req.Headers == nil {
+						req.Headers = map[string]string{}
+					}
+					req.Headers["X-Trace-ID"] = "trace-1"
+					return next(ctx, req)
+				},
+				func(ctx context.Context, req *Request, next func(context.Context, *Request) (*Response, error)) (*Response, error) {
+					order = append(order, "count")
+					calls++
+					return next(ctx, req)
+				},
+			},
+		},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := client.GET(context.Background(), "/test", nil, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if header != "trace-1" {
+		t.Errorf("Expected injected header 'trace-1', got '%s'", header)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 recorded calls, got %d", calls)
+	}
+	if strings.Join(order, ",") != "trace,count,trace,count" {
+		t.Errorf("Expected interceptors to run in order, got %v", order)
+	}
+}
+
+// recordingMetrics records every observation for assertions
+type recordingMetrics struct {
+	observations []observation
+}
+
+type observation struct {
+	method string
+	path   string
+	status int
+	dur    time.Duration
+}
+
+func (m *recordingMetrics) ObserveRequest(method, path string, status int, dur time.Duration) {
+	m.observations = append(m.observations, observation{method, path, status, dur})
+}
+
+func TestClientMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(time.Millisecond)
+		return newMockResponse(201, nil), nil
+	})
+
+	client := &Client{
+		config:     &Config{Metrics: metrics},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+
+	if err := client.POST(context.Background(), "/test", nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(metrics.observations) != 1 {
+		t.Fatalf("Expected 1 observation, got %d", len(metrics.observations))
+	}
+	obs := metrics.observations[0]
+	if obs.method != "POST" || obs.path != "/test" || obs.status != 201 {
+		t.Errorf("Expected POST /test 201, got %+v", obs)
+	}
+	if obs.dur <= 0 {
+		t.Errorf("Expected non-zero duration, got %v", obs.dur)
+	}
+}
+
+func TestClientRetryIdempotent(t *testing.T) {
+	attempts := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts <= 2 {
+			return newMockResponse(503, ErrorResponse{Message: "unavailable"}), nil
+		}
+		return newMockResponse(200, map[string]string{"result": "ok"}), nil
+	})
+
+	client := &Client{
+		config:     &Config{MaxRetries: 3, RetryBaseDelay: time.Millisecond},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	if err := client.GET(context.Background(), "/test", nil, &result); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if result.Result != "ok" {
+		t.Errorf("Expected result 'ok', got '%s'", result.Result)
+	}
+}
+
+func TestClientRetryExhausted(t *testing.T) {
+	attempts := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return newMockResponse(502, ErrorResponse{Message: "bad gateway"}), nil
+	})
+
+	client := &Client{
+		config:     &Config{MaxRetries: 2, RetryBaseDelay: time.Millisecond},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+
+	err := client.PUT(context.Background(), "/test", map[string]string{}, nil)
+	if err == nil {
+		t.Fatal("Expected error after retries are exhausted")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestClientRetryPOST(t *testing.T) {
+	attempts := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return newMockResponse(503, ErrorResponse{Message: "unavailable"}), nil
+	})
+
+	client := &Client{
+		config:     &Config{MaxRetries: 3, RetryBaseDelay: time.Millisecond},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+
+	// A POST that reached the server must not be replayed
+	if err := client.POST(context.Background(), "/test", nil, nil); err == nil {
+		t.Fatal("Expected error for 503 response")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+
+	// A POST that never connected is safe to retry
+	attempts = 0
+	client.httpClient = MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		}
+		return newMockResponse(200, nil), nil
+	})
+	if err := client.POST(context.Background(), "/test", nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestClientRetryRespectsDeadline(t *testing.T) {
+	attempts := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return newMockResponse(503, ErrorResponse{Message: "unavailable"}), nil
+	})
+
+	client := &Client{
+		config:     &Config{MaxRetries: 5, RetryBaseDelay: time.Second},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := client.GET(ctx, "/test", nil, nil); err == nil {
+		t.Fatal("Expected error")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expected retry to stop at the context deadline, took %v", time.Since(start))
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestClientRetryDelayCapped(t *testing.T) {
+	client := &Client{config: &Config{RetryBaseDelay: 500 * time.Millisecond}}
+	for _, attempt := range []int{10, 63, 64, 1000} {
+		delay := client.retryDelay(attempt)
+		if delay < DefaultRetryMaxDelay/2 || delay > DefaultRetryMaxDelay {
+			t.Errorf("Expected attempt %d to wait within [%v, %v], got %v", attempt, DefaultRetryMaxDelay/2, DefaultRetryMaxDelay, delay)
+		}
+	}
+
+	client.config.RetryMaxDelay = 2 * time.Second
+	if delay := client.retryDelay(1000); delay < time.Second || delay > 2*time.Second {
+		t.Errorf("Expected RetryMaxDelay to cap the backoff at 2s, got %v", delay)
+	}
+	if delay := client.retryDelay(0); delay < 250*time.Millisecond || delay > 500*time.Millisecond {
+		t.Errorf("Expected the first retry to wait about the base delay, got %v", delay)
+	}
+}
+
+func TestClientRateLimitStatus(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		resp := newMockResponse(200, nil)
+		if req.URL.Path == "/limited" {
+			resp.Header.Set("X-RateLimit-Remaining", "42")
+			resp.Header.Set("X-RateLimit-Reset", "1700000000")
+		}
+		return resp, nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+
+	if err := client.GET(context.Background(), "/plain", nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, _, ok := client.RateLimitStatus(); ok {
+		t.Error("Expected no rate limit status before the headers are seen")
+	}
+
+	if err := client.GET(context.Background(), "/limited", nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	remaining, reset, ok := client.RateLimitStatus()
+	if !ok {
+		t.Fatal("Expected rate limit status after the headers are seen")
+	}
+	if remaining != 42 {
+		t.Errorf("Expected remaining 42, got %d", remaining)
+	}
+	if !reset.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Expected reset at 1700000000, got %v", reset)
+	}
+
+	// Responses without the headers keep the last known values
+	if err := client.GET(context.Background(), "/plain", nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if remaining, _, ok := client.RateLimitStatus(); !ok || remaining != 42 {
+		t.Errorf("Expected remaining 42 to be kept, got %d (ok=%v)", remaining, ok)
+	}
+}
+
+func TestClientCallIdempotentRetry(t *testing.T) {
+	attempts := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return newMockResponse(503, ErrorResponse{Message: "unavailable"}), nil
+		}
+		return newMockResponse(200, map[string]string{"result": "ok"}), nil
+	})
+
+	client := &Client{
+		config:     &Config{MaxRetries: 3, RetryBaseDelay: time.Millisecond},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+
+	// A POST declared idempotent is retried
+	var result struct {
+		Result string `json:"result"`
+	}
+	err := client.Call(context.Background(), &Request{
+		Method:     http.MethodPost,
+		Path:       "/test",
+		Body:       map[string]string{"key": "value"},
+		Idempotent: true,
+	}, &result)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+	if result.Result != "ok" {
+		t.Errorf("Expected result 'ok', got '%s'", result.Result)
+	}
+
+	// A default POST is not
+	attempts = 0
+	err = client.Call(context.Background(), &Request{
+		Method: http.MethodPost,
+		Path:   "/test",
+		Body:   map[string]string{"key": "value"},
+	}, nil)
+	if err == nil {
+		t.Fatal("Expected error for 503 response")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestClientLogBodies(t *testing.T) {
+	logger := &captureLogger{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		return newMockResponse(200, map[string]string{
+			"access_token": "syt_secret",
+			"user_id":      "@bot:localhost",
+		}), nil
+	})
+
+	client := &Client{
+		config:     &Config{Logger: logger, LogBodies: true, LogBodyLimit: 64},
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+
+	err := client.POST(context.Background(), "/login", map[string]string{
+		"type":     "m.login.password",
+		"password": "hunter2",
+		"padding":  strings.Repeat("x", 200),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	output := strings.Join(logger.lines, "\n")
+	if strings.Contains(output, "hunter2") || strings.Contains(output, "syt_secret") {
+		t.Errorf("Expected secrets to be redacted, got:\n%s", output)
+	}
+	if !strings.Contains(output, `"access_token":"REDACTED"`) {
+		t.Errorf("Expected redacted access_token in response body, got:\n%s", output)
+	}
+
+	var requestLine string
+	for _, line := range logger.lines {
+		if strings.HasPrefix(line, "taibai: --> POST /login body=") {
+			requestLine = line
+		}
+	}
+	if requestLine == "" {
+		t.Fatalf("Expected request body to be logged, got:\n%s", output)
+	}
+	body := strings.TrimPrefix(requestLine, "taibai: --> POST /login body=")
+	if !strings.HasSuffix(body, "bytes truncated)") {
+		t.Errorf("Expected truncated body, got %s", body)
+	}
+	if logged := body[:strings.Index(body, "... (")]; len(logged) != 64 {
+		t.Errorf("Expected body truncated at 64 bytes, got %d", len(logged))
+	}
+}
+
+func TestClientFormatBodyRuneBoundary(t *testing.T) {
+	client := &Client{config: &Config{LogBodyLimit: 10}}
+
+	// Each rune of "消息内容" is 3 bytes; byte 10 falls inside "息"
+	got := client.formatBody([]byte(`{"a":"消息内容"}`))
+	logged := got[:strings.Index(got, "... (")]
+	if !utf8.ValidString(logged) {
+		t.Errorf("Expected truncation on a rune boundary, got %q", logged)
+	}
+	if logged != `{"a":"消` {
+		t.Errorf("Expected '{\"a\":\"消', got %q", logged)
+	}
+	if !strings.HasSuffix(got, "(11 bytes truncated)") {
+		t.Errorf("Expected 11 bytes truncated, got %s", got)
+	}
+}
+
+func TestClientLogBodiesSkipsMedia(t *testing.T) {
+	logger := &captureLogger{}
+	client := &Client{
+		config:     &Config{Logger: logger, LogBodies: true},
+		httpClient: &MockHTTPClient{Response: newMockResponse(200, map[string]string{"content_uri": "mxc://localhost/abc"})},
+		baseURL:    "http://localhost:8008",
+	}
+
+	client.do(context.Background(), &Request{
+		Method: "POST",
+		Path:   mediaPrefix + "/upload",
+		Body:   strings.NewReader("binary"),
+	})
+
+	for _, line := range logger.lines {
+		if strings.Contains(line, "body=") {
+			t.Errorf("Expected media bodies not to be logged, got %s", line)
+		}
+	}
+}
+
+func TestClientDecodeError(t *testing.T) {
+	html := "<html><body>502 Bad Gateway</body></html>"
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(strings.NewReader(html)),
+			Header:     http.Header{"Content-Type": []string{"text/html"}},
+		}, nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+	}
+
+	var result map[string]interface{}
+	err := client.GET(context.Background(), "/test", nil, &result)
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("Expected *DecodeError, got %T: %v", err, err)
+	}
+	if string(decodeErr.Body) != html {
+		t.Errorf("Expected raw body %q, got %q", html, decodeErr.Body)
+	}
+	if decodeErr.ContentType != "text/html" {
+		t.Errorf("Expected content type 'text/html', got '%s'", decodeErr.ContentType)
+	}
+	if decodeErr.StatusCode != 200 {
+		t.Errorf("Expected status 200, got %d", decodeErr.StatusCode)
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("Expected the JSON syntax error to be unwrappable, got %v", decodeErr.Err)
+	}
+}
@@ -1,6 +1,10 @@
 package taibai
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"time"
 )
 
@@ -21,16 +25,131 @@ type Config struct {
 	// IdleConnTimeout timeout for idle connections (default: 90 seconds)
 	IdleConnTimeout time.Duration
 
+	// MaxRetries is the number of times a failed request is retried (default: 0)
+	MaxRetries int
+
+	// RetryBaseDelay is the initial backoff between retries, doubled on
+	// every attempt with random jitter (default: 500 milliseconds)
+	RetryBaseDelay time.Duration
+
+	// RetryMaxDelay caps the backoff between retries before jitter is
+	// applied (default: DefaultRetryMaxDelay)
+	RetryMaxDelay time.Duration
+
+	// RetryableStatusCodes are the HTTP status codes that trigger a retry of
+	// idempotent requests (default: DefaultRetryableStatusCodes)
+	RetryableStatusCodes []int
+
+	// APIVersion is the client-server API version used in endpoint paths,
+	// e.g. "r0" or "v3" (default: "r0"). Admin endpoints are not affected.
+	APIVersion string
+
+	// OrderedRoomSends serializes SendMessage calls per room so that concurrent
+	// sends to the same room reach the server in submission order
+	OrderedRoomSends bool
+
+	// MediaUploadCacheSize enables a per-process cache of uploaded media keyed
+	// by SHA-256, content type and filename, so re-uploading identical bytes
+	// with the same metadata returns the earlier mxc:// URI. It bounds the
+	// number of remembered uploads (default: 0, disabled).
+	MediaUploadCacheSize int
+
+	// UserAgent is sent as the User-Agent header (default: DefaultUserAgent)
+	UserAgent string
+
+	// DefaultHeaders are added to every request; per-request headers take
+	// precedence
+	DefaultHeaders map[string]string
+
+	// ProxyURL routes requests through an HTTP(S) or SOCKS5 proxy, e.g.
+	// "http://proxy:3128" or "socks5://proxy:1080" (default: the
+	// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables)
+	ProxyURL string
+
+	// Logger receives debug and error logs of every request (default: no
+	// logging). Authorization headers are redacted.
+	Logger Logger
+
+	// LogBodies additionally logs JSON request and response bodies at debug
+	// level, with tokens and passwords redacted. Media transfers are never
+	// logged. Only enable it while debugging.
+	LogBodies bool
+
+	// LogBodyLimit truncates logged bodies to this many bytes (default:
+	// DefaultLogBodyLimit)
+	LogBodyLimit int
+
+	// Interceptors wrap every request, outermost first, e.g. for tracing or
+	// custom auth. Each must call next to continue the chain.
+	Interceptors []Interceptor
+
+	// Metrics observes every HTTP attempt (optional)
+	Metrics Metrics
+
 	// TLSConfig TLS configuration (optional)
-	// TLSConfig *tls.Config
+	TLSConfig *tls.Config
+
+	// InsecureSkipVerify disables server certificate verification. Only use
+	// it against development servers.
+	InsecureSkipVerify bool
+}
+
+// Interceptor is a middleware around Client requests. It may modify the
+// request, short-circuit it or inspect the response returned by next.
+type Interceptor func(ctx context.Context, req *Request, next func(ctx context.Context, req *Request) (*Response, error)) (*Response, error)
+
+// Metrics receives request observations, e.g. to feed Prometheus counters
+// and histograms. Paths contain room and event IDs; collectors should
+// normalize them before using them as labels.
+type Metrics interface {
+	// ObserveRequest is called once per HTTP attempt. status is 0 when no
+	// response was received.
+	ObserveRequest(method, path string, status int, dur time.Duration)
+}
+
+// DefaultLogBodyLimit is the default size cap of bodies logged with LogBodies
+const DefaultLogBodyLimit = 1024
+
+// Logger is the logging hook used by Client
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
 }
 
+// noopLogger discards all logs
+type noopLogger struct{}
+
+func (noopLogger) Debugf(format string, args ...interface{}) {}
+func (noopLogger) Errorf(format string, args ...interface{}) {}
+
+// Version is the version of the SDK
+const Version = "0.1.0"
+
+// DefaultUserAgent is the User-Agent sent when Config.UserAgent is empty
+const DefaultUserAgent = "taibai-go/" + Version
+
+// DefaultAPIVersion is the client-server API version used when
+// Config.APIVersion is empty
+const DefaultAPIVersion = "r0"
+
+// DefaultRetryMaxDelay is the backoff cap used when Config.RetryMaxDelay is
+// not set
+const DefaultRetryMaxDelay = 30 * time.Second
+
+// DefaultRetryableStatusCodes are the status codes retried when
+// Config.RetryableStatusCodes is empty
+var DefaultRetryableStatusCodes = []int{500, 502, 503, 504}
+
 // DefaultConfig returns a Config with default values
 func DefaultConfig() *Config {
 	return &Config{
 		Timeout:            30 * time.Second,
 		MaxIdleConnections: 10,
 		IdleConnTimeout:    90 * time.Second,
+		RetryBaseDelay:     500 * time.Millisecond,
+		RetryMaxDelay:      DefaultRetryMaxDelay,
+		APIVersion:         DefaultAPIVersion,
+		UserAgent:          DefaultUserAgent,
 	}
 }
 
@@ -48,6 +167,18 @@ func (c *Config) Validate() error {
 	if c.IdleConnTimeout <= 0 {
 		c.IdleConnTimeout = 90 * time.Second
 	}
+	if c.APIVersion == "" {
+		c.APIVersion = DefaultAPIVersion
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = DefaultUserAgent
+	}
+	if c.RetryBaseDelay <= 0 {
+		c.RetryBaseDelay = 500 * time.Millisecond
+	}
+	if c.RetryMaxDelay <= 0 {
+		c.RetryMaxDelay = DefaultRetryMaxDelay
+	}
 	return nil
 }
 
@@ -68,12 +199,83 @@ func (e *ConfigError) Error() string {
 	return e.msg
 }
 
+// Matrix error codes returned in the errcode field
+const (
+	ErrcodeUnknown       = "M_UNKNOWN"
+	ErrcodeForbidden     = "M_FORBIDDEN"
+	ErrcodeUnknownToken  = "M_UNKNOWN_TOKEN"
+	ErrcodeNotFound      = "M_NOT_FOUND"
+	ErrcodeLimitExceeded = "M_LIMIT_EXCEEDED"
+)
+
 // APIError represents an API error response
 type APIError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+
+	// Errcode is the Matrix error code (e.g., "M_FORBIDDEN"), if returned
+	Errcode string `json:"errcode,omitempty"`
+
+	// RetryAfterMs is how long to wait before retrying a rate-limited
+	// request, if returned
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
 }
 
 func (e *APIError) Error() string {
 	return e.Message
 }
+
+// DecodeError is returned when a successful response body cannot be decoded,
+// e.g. when a proxy answers with an HTML page. It keeps the raw body for
+// diagnosis.
+type DecodeError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+
+	// ContentType is the Content-Type header of the response
+	ContentType string
+
+	// Body is the raw response body
+	Body []byte
+
+	// Err is the underlying decoding error
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to unmarshal response (status %d, content-type %q): %v", e.StatusCode, e.ContentType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// MatrixError is the error returned for Matrix {"errcode", "error"} bodies
+type MatrixError = APIError
+
+// Errcode returns the Matrix error code of err, or "" if it carries none
+func Errcode(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Errcode
+	}
+	return ""
+}
+
+// IsRateLimited reports whether err is an M_LIMIT_EXCEEDED / 429 error
+func IsRateLimited(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.Errcode == ErrcodeLimitExceeded || apiErr.Code == 429)
+}
+
+// IsNotFound reports whether err is an M_NOT_FOUND / 404 error
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.Errcode == ErrcodeNotFound || apiErr.Code == 404)
+}
+
+// IsForbidden reports whether err is an M_FORBIDDEN / 403 error
+func IsForbidden(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.Errcode == ErrcodeForbidden || apiErr.Code == 403)
+}
@@ -0,0 +1,137 @@
+package taibai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// discoveryHTTPClient performs .well-known lookups; tests replace it
+var discoveryHTTPClient HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// wellKnownClient is the .well-known/matrix/client document
+type wellKnownClient struct {
+	Homeserver struct {
+		BaseURL string `json:"base_url"`
+	} `json:"m.homeserver"`
+}
+
+// DiscoverHomeserver resolves the homeserver base URL of domain from
+// https://{domain}/.well-known/matrix/client. If the server has no
+// well-known document (404), https://{domain} is returned. The result can be
+// used as Config.ServerAddress.
+func DiscoverHomeserver(ctx context.Context, domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), "/")
+	if domain == "" {
+		return "", fmt.Errorf("domain is required")
+	}
+	fallback := "https://" + domain
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fallback+"/.well-known/matrix/client", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", DefaultUserAgent)
+
+	resp, err := discoveryHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("well-known lookup failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fallback, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("well-known lookup failed with status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read well-known document: %w", err)
+	}
+
+	var doc wellKnownClient
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("invalid well-known document: %w", err)
+	}
+	if doc.Homeserver.BaseURL == "" {
+		return "", fmt.Errorf("invalid well-known document: missing m.homeserver.base_url")
+	}
+
+	return strings.TrimSuffix(doc.Homeserver.BaseURL, "/"), nil
+}
+
+// VersionsResponse represents the spec versions supported by the server
+type VersionsResponse struct {
+	// Versions lists the supported spec versions, e.g. "r0.6.1" or "v1.1"
+	Versions []string `json:"versions"`
+
+	// UnstableFeatures maps unstable feature flags to whether they are enabled
+	UnstableFeatures map[string]bool `json:"unstable_features,omitempty"`
+}
+
+// SupportsVersion reports whether the server advertises version
+func (v *VersionsResponse) SupportsVersion(version string) bool {
+	for _, supported := range v.Versions {
+		if supported == version {
+			return true
+		}
+	}
+	return false
+}
+
+// CapabilitiesResponse represents the capabilities of the server
+type CapabilitiesResponse struct {
+	// Capabilities contains the capability blocks
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// Capabilities contains the known capability blocks; absent blocks are nil
+type Capabilities struct {
+	// ChangePassword reports whether users may change their password
+	ChangePassword *ChangePasswordCapability `json:"m.change_password,omitempty"`
+
+	// RoomVersions describes the room versions the server supports
+	RoomVersions *RoomVersionsCapability `json:"m.room_versions,omitempty"`
+}
+
+// ChangePasswordCapability is the m.change_password capability
+type ChangePasswordCapability struct {
+	// Enabled is true if users may change their password
+	Enabled bool `json:"enabled"`
+}
+
+// RoomVersionsCapability is the m.room_versions capability
+type RoomVersionsCapability struct {
+	// Default is the room version used for new rooms
+	Default string `json:"default"`
+
+	// Available maps room versions to their stability ("stable" or "unstable")
+	Available map[string]string `json:"available"`
+}
+
+// GetVersions gets the spec versions supported by the server
+func (c *Client) GetVersions(ctx context.Context) (*VersionsResponse, error) {
+	result := &VersionsResponse{}
+	err := c.GET(ctx, "/_matrix/client/versions", nil, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetCapabilities gets the capabilities of the server for the current user
+func (c *Client) GetCapabilities(ctx context.Context) (*CapabilitiesResponse, error) {
+	result := &CapabilitiesResponse{}
+	err := c.GET(ctx, c.apiPrefix()+"/capabilities", nil, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package taibai
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// withDiscoveryClient swaps the .well-known HTTP client for the test
+func withDiscoveryClient(t *testing.T, client HTTPClient) {
+	t.Helper()
+
+	original := discoveryHTTPClient
+	discoveryHTTPClient = client
+	t.Cleanup(func() { discoveryHTTPClient = original })
+}
+
+func TestDiscoverHomeserver(t *testing.T) {
+	var url string
+	withDiscoveryClient(t, MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		url = req.URL.String()
+		return newMockResponse(200, map[string]interface{}{
+			"m.homeserver": map[string]string{"base_url": "https://matrix.example.com/"},
+		}), nil
+	}))
+
+	baseURL, err := DiscoverHomeserver(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if url != "https://example.com/.well-known/matrix/client" {
+		t.Errorf("Expected well-known URL, got '%s'", url)
+	}
+	if baseURL != "https://matrix.example.com" {
+		t.Errorf("Expected base URL 'https://matrix.example.com', got '%s'", baseURL)
+	}
+}
+
+func TestDiscoverHomeserverNotFound(t *testing.T) {
+	withDiscoveryClient(t, MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		return newMockResponse(404, nil), nil
+	}))
+
+	baseURL, err := DiscoverHomeserver(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if baseURL != "https://example.com" {
+		t.Errorf("Expected fallback 'https://example.com', got '%s'", baseURL)
+	}
+}
+
+func TestDiscoverHomeserverMalformed(t *testing.T) {
+	withDiscoveryClient(t, MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"m.homeserver":`))),
+			Header:     make(http.Header),
+		}, nil
+	}))
+
+	if _, err := DiscoverHomeserver(context.Background(), "example.com"); err == nil {
+		t.Error("Expected error for malformed well-known document")
+	}
+}
+
+func TestGetVersionsAndCapabilities(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/_matrix/client/versions":
+			return newMockResponse(200, map[string]interface{}{
+				"versions":          []string{"r0.6.1", "v1.1"},
+				"unstable_features": map[string]bool{"org.matrix.msc3440.stable": true},
+			}), nil
+		case "/_matrix/client/r0/capabilities":
+			return newMockResponse(200, map[string]interface{}{
+				"capabilities": map[string]interface{}{
+					"m.change_password": map[string]bool{"enabled": false},
+					"m.room_versions": map[string]interface{}{
+						"default":   "9",
+						"available": map[string]string{"9": "stable", "org.example.v11": "unstable"},
+					},
+				},
+			}), nil
+		}
+		t.Errorf("Unexpected path %s", req.URL.Path)
+		return newMockResponse(404, nil), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+
+	versions, err := client.GetVersions(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(versions.Versions) != 2 || !versions.SupportsVersion("v1.1") || versions.SupportsVersion("v1.2") {
+		t.Errorf("Expected versions [r0.6.1 v1.1], got %v", versions.Versions)
+	}
+	if !versions.UnstableFeatures["org.matrix.msc3440.stable"] {
+		t.Errorf("Expected unstable feature to be enabled, got %v", versions.UnstableFeatures)
+	}
+
+	caps, err := client.GetCapabilities(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if caps.Capabilities.RoomVersions == nil || caps.Capabilities.RoomVersions.Default != "9" {
+		t.Fatalf("Expected default room version '9', got %+v", caps.Capabilities.RoomVersions)
+	}
+	if caps.Capabilities.RoomVersions.Available["org.example.v11"] != "unstable" {
+		t.Errorf("Expected org.example.v11 to be unstable, got %v", caps.Capabilities.RoomVersions.Available)
+	}
+	if caps.Capabilities.ChangePassword == nil || caps.Capabilities.ChangePassword.Enabled {
+		t.Errorf("Expected m.change_password disabled, got %+v", caps.Capabilities.ChangePassword)
+	}
+}
@@ -2,4 +2,4 @@ module diting/taibai/sdk/go
 
 go 1.22.2
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
@@ -2,8 +2,12 @@ package taibai
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // ============ 消息类型定义 ============
@@ -25,10 +29,14 @@ const (
 	EventUserMessage     = "user_message"      // 用户消息
 	EventCardCallback    = "card_callback"     // 卡片回调
 	EventApprovalChange  = "approval_change"   // 审批状态变更
+	EventReaction        = "reaction"          // 表情回应
 	EventSubscribe       = "subscribe"         // 订阅事件
 	EventUnsubscribe     = "unsubscribe"       // 取消订阅
 	EventPing            = "ping"              // 心跳
 	EventPong            = "pong"              // 心跳响应
+	EventError           = "error"             // 服务端错误
+	EventCancel          = "cancel"            // 取消关联请求
+	EventAck             = "ack"               // 消息确认
 )
 
 // ============ 消息结构体 ============
@@ -70,6 +78,19 @@ type ApprovalChange struct {
 	Raw           json.RawMessage `json:"raw"`   // 原始消息
 }
 
+// Reaction 表情回应
+type Reaction struct {
+	EventID       string          `json:"event_id"`        // 回应事件 ID
+	TargetEventID string          `json:"target_event_id"` // 被回应的消息 ID
+	Key           string          `json:"key"`             // 回应内容, 如 "👍"
+	UserID        string          `json:"user_id"`         // 用户 ID
+	UserName      string          `json:"user_name"`       // 用户名称
+	ChannelID     string          `json:"channel_id"`      // 频道 ID
+	GroupID       string          `json:"group_id"`        // 群组 ID (可选)
+	Timestamp     int64           `json:"timestamp"`       // 时间戳
+	Raw           json.RawMessage `json:"raw"`             // 原始消息
+}
+
 // ============ 消息处理器 ============
 
 // MessageHandler 消息处理器
@@ -83,8 +104,33 @@ type MessageHandler struct {
 	// 审批状态变更处理
 	ApprovalChangeHandlers []func(change *ApprovalChange)
 
+	// 表情回应处理
+	ReactionHandlers []func(reaction *Reaction)
+
 	// 系统消息处理
 	SystemHandlers []func(event string, data json.RawMessage)
+
+	// 可返回错误的处理函数, 在上面同类处理函数之后调用, 错误由 Handle 汇总返回
+	userMessageErrHandlers    []func(msg *UserMessage) error
+	cardCallbackErrHandlers   []func(callback *CardCallback) error
+	approvalChangeErrHandlers []func(change *ApprovalChange) error
+	reactionErrHandlers       []func(reaction *Reaction) error
+	systemErrHandlers         []func(event string, data json.RawMessage) error
+
+	// 命令处理, 按注册顺序匹配
+	commandHandlers []commandHandler
+
+	// 未匹配任何命令时的处理 (可选)
+	defaultCommandHandler func(msg *UserMessage, args []string)
+
+	// 中间件, 按注册顺序由外到内包裹分发
+	middlewares []Middleware
+}
+
+// commandHandler 命令及其处理函数
+type commandHandler struct {
+	prefix string
+	fn     func(msg *UserMessage, args []string)
 }
 
 // NewMessageHandler 创建消息处理器
@@ -93,6 +139,7 @@ func NewMessageHandler() *MessageHandler {
 		UserMessageHandlers:    make([]func(msg *UserMessage), 0),
 		CardCallbackHandlers:   make([]func(callback *CardCallback), 0),
 		ApprovalChangeHandlers: make([]func(change *ApprovalChange), 0),
+		ReactionHandlers:       make([]func(reaction *Reaction), 0),
 		SystemHandlers:         make([]func(event string, data json.RawMessage), 0),
 	}
 }
@@ -102,23 +149,81 @@ func (h *MessageHandler) OnUserMessage(fn func(msg *UserMessage)) {
 	h.UserMessageHandlers = append(h.UserMessageHandlers, fn)
 }
 
+// OnUserMessageErr 注册可返回错误的用户消息处理函数, 错误由 Handle 汇总返回
+func (h *MessageHandler) OnUserMessageErr(fn func(msg *UserMessage) error) {
+	h.userMessageErrHandlers = append(h.userMessageErrHandlers, fn)
+}
+
 // OnCardCallback 注册卡片回调处理函数
 func (h *MessageHandler) OnCardCallback(fn func(callback *CardCallback)) {
 	h.CardCallbackHandlers = append(h.CardCallbackHandlers, fn)
 }
 
+// OnCardCallbackErr 注册可返回错误的卡片回调处理函数
+func (h *MessageHandler) OnCardCallbackErr(fn func(callback *CardCallback) error) {
+	h.cardCallbackErrHandlers = append(h.cardCallbackErrHandlers, fn)
+}
+
 // OnApprovalChange 注册审批状态变更处理函数
 func (h *MessageHandler) OnApprovalChange(fn func(change *ApprovalChange)) {
 	h.ApprovalChangeHandlers = append(h.ApprovalChangeHandlers, fn)
 }
 
+// OnApprovalChangeErr 注册可返回错误的审批状态变更处理函数
+func (h *MessageHandler) OnApprovalChangeErr(fn func(change *ApprovalChange) error) {
+	h.approvalChangeErrHandlers = append(h.approvalChangeErrHandlers, fn)
+}
+
+// OnReaction 注册表情回应处理函数
+func (h *MessageHandler) OnReaction(fn func(reaction *Reaction)) {
+	h.ReactionHandlers = append(h.ReactionHandlers, fn)
+}
+
+// OnReactionErr 注册可返回错误的表情回应处理函数
+func (h *MessageHandler) OnReactionErr(fn func(reaction *Reaction) error) {
+	h.reactionErrHandlers = append(h.reactionErrHandlers, fn)
+}
+
 // OnSystem 注册系统消息处理函数
 func (h *MessageHandler) OnSystem(fn func(event string, data json.RawMessage)) {
 	h.SystemHandlers = append(h.SystemHandlers, fn)
 }
 
+// OnSystemErr 注册可返回错误的系统消息处理函数
+func (h *MessageHandler) OnSystemErr(fn func(event string, data json.RawMessage) error) {
+	h.systemErrHandlers = append(h.systemErrHandlers, fn)
+}
+
+// OnCommand 注册命令处理函数。用户消息的第一个词与 prefix (如 "/deploy")
+// 完全相同时调用 fn, args 为其后的参数, 支持用单引号或双引号包含空格
+func (h *MessageHandler) OnCommand(prefix string, fn func(msg *UserMessage, args []string)) {
+	h.commandHandlers = append(h.commandHandlers, commandHandler{prefix: prefix, fn: fn})
+}
+
+// OnDefaultCommand 注册未匹配任何命令时的处理函数, args[0] 为消息的第一个词。
+// 仅在第一个词看起来像命令时调用: 以某个已注册命令的首字符开头, 未注册命令时
+// 以 "/" 开头; 普通聊天消息不会触发
+func (h *MessageHandler) OnDefaultCommand(fn func(msg *UserMessage, args []string)) {
+	h.defaultCommandHandler = fn
+}
+
+// Use 注册中间件。先注册的中间件在外层, 可在调用 next 前后加入日志、鉴权等逻辑,
+// 也可以不调用 next 直接拦截消息
+func (h *MessageHandler) Use(mw Middleware) {
+	h.middlewares = append(h.middlewares, mw)
+}
+
 // Handle 处理 WebSocket 消息
 func (h *MessageHandler) Handle(wsMsg *WSMessage) error {
+	next := HandlerFunc(h.dispatch)
+	for i := len(h.middlewares) - 1; i >= 0; i-- {
+		next = h.middlewares[i](next)
+	}
+	return next(wsMsg)
+}
+
+// dispatch 按事件类型分发消息
+func (h *MessageHandler) dispatch(wsMsg *WSMessage) error {
 	switch wsMsg.Event {
 	case EventUserMessage:
 		return h.handleUserMessage(wsMsg.Payload)
@@ -126,6 +231,15 @@ func (h *MessageHandler) Handle(wsMsg *WSMessage) error {
 		return h.handleCardCallback(wsMsg.Payload)
 	case EventApprovalChange:
 		return h.handleApprovalChange(wsMsg.Payload)
+	case EventReaction:
+		return h.handleReaction(wsMsg.Payload)
+	case EventError:
+		// 错误帧不交给系统消息处理函数, 以 *WSError 返回
+		wsErr, err := ParseWSError(wsMsg.Payload)
+		if err != nil {
+			return err
+		}
+		return wsErr
 	default:
 		return h.handleSystem(wsMsg.Event, wsMsg.Payload)
 	}
@@ -143,12 +257,100 @@ func (h *MessageHandler) handleUserMessage(payload json.RawMessage) error {
 		msg.Timestamp = time.Now().Unix()
 	}
 
-	// 调用所有处理函数
+	// 调用所有处理函数, 一个处理函数出错不影响其余处理函数
 	for _, fn := range h.UserMessageHandlers {
 		fn(&msg)
 	}
+	var errs []error
+	for _, fn := range h.userMessageErrHandlers {
+		if err := fn(&msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	h.dispatchCommand(&msg)
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// dispatchCommand 按命令分发用户消息
+func (h *MessageHandler) dispatchCommand(msg *UserMessage) {
+	if len(h.commandHandlers) == 0 && h.defaultCommandHandler == nil {
+		return
+	}
+
+	args := SplitCommandArgs(msg.Content)
+	if len(args) == 0 {
+		return
+	}
+
+	for _, cmd := range h.commandHandlers {
+		if args[0] == cmd.prefix {
+			cmd.fn(msg, args[1:])
+			return
+		}
+	}
+
+	if h.defaultCommandHandler != nil && h.looksLikeCommand(args[0]) {
+		h.defaultCommandHandler(msg, args)
+	}
+}
+
+// looksLikeCommand 判断 word 是否以命令前缀字符开头
+func (h *MessageHandler) looksLikeCommand(word string) bool {
+	if len(h.commandHandlers) == 0 {
+		return strings.HasPrefix(word, "/")
+	}
+	for _, cmd := range h.commandHandlers {
+		r, size := utf8.DecodeRuneInString(cmd.prefix)
+		if size > 0 && strings.HasPrefix(word, string(r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitCommandArgs 按空白切分命令参数。单引号或双引号内的空白不切分,
+// 双引号内可用反斜杠转义; 未闭合的引号延续到末尾
+func SplitCommandArgs(content string) []string {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range content {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote != 0:
+			if r == '\\' && quote == '"' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args
 }
 
 // handleCardCallback 处理卡片回调
@@ -167,8 +369,14 @@ func (h *MessageHandler) handleCardCallback(payload json.RawMessage) error {
 	for _, fn := range h.CardCallbackHandlers {
 		fn(&callback)
 	}
+	var errs []error
+	for _, fn := range h.cardCallbackErrHandlers {
+		if err := fn(&callback); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 // handleApprovalChange 处理审批状态变更
@@ -187,8 +395,40 @@ func (h *MessageHandler) handleApprovalChange(payload json.RawMessage) error {
 	for _, fn := range h.ApprovalChangeHandlers {
 		fn(&change)
 	}
+	var errs []error
+	for _, fn := range h.approvalChangeErrHandlers {
+		if err := fn(&change); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// handleReaction 处理表情回应
+func (h *MessageHandler) handleReaction(payload json.RawMessage) error {
+	var reaction Reaction
+	if err := json.Unmarshal(payload, &reaction); err != nil {
+		return fmt.Errorf("解析表情回应失败: %w", err)
+	}
+
+	// 设置时间戳
+	if reaction.Timestamp == 0 {
+		reaction.Timestamp = time.Now().Unix()
+	}
+
+	// 调用所有处理函数
+	for _, fn := range h.ReactionHandlers {
+		fn(&reaction)
+	}
+	var errs []error
+	for _, fn := range h.reactionErrHandlers {
+		if err := fn(&reaction); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 // handleSystem 处理系统消息
@@ -196,7 +436,13 @@ func (h *MessageHandler) handleSystem(event string, data json.RawMessage) error
 	for _, fn := range h.SystemHandlers {
 		fn(event, data)
 	}
-	return nil
+	var errs []error
+	for _, fn := range h.systemErrHandlers {
+		if err := fn(event, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // ============ 消息工具函数 ============
@@ -228,8 +474,38 @@ func ParseApprovalChange(data json.RawMessage) (*ApprovalChange, error) {
 	return &change, nil
 }
 
+// ParseReaction 解析表情回应
+func ParseReaction(data json.RawMessage) (*Reaction, error) {
+	var reaction Reaction
+	if err := json.Unmarshal(data, &reaction); err != nil {
+		return nil, err
+	}
+	return &reaction, nil
+}
+
 // ============ 便捷的回调包装器 ============
 
+// HandlerFunc 消息处理函数类型, 即被中间件包裹的 Handle
+type HandlerFunc func(wsMsg *WSMessage) error
+
+// Middleware 消息处理中间件
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// RecoverMiddleware 返回捕获处理函数 panic 的中间件, panic 转为错误返回,
+// 避免一个出错的处理函数导致读循环崩溃
+func RecoverMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(wsMsg *WSMessage) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("处理 %s 消息时发生 panic: %v", wsMsg.Event, r)
+				}
+			}()
+			return next(wsMsg)
+		}
+	}
+}
+
 // UserMessageHandlerFunc 用户消息处理函数类型
 type UserMessageHandlerFunc func(msg *UserMessage)
 
@@ -239,6 +515,9 @@ type CardCallbackHandlerFunc func(callback *CardCallback)
 // ApprovalChangeHandlerFunc 审批状态变更处理函数类型
 type ApprovalChangeHandlerFunc func(change *ApprovalChange)
 
+// ReactionHandlerFunc 表情回应处理函数类型
+type ReactionHandlerFunc func(reaction *Reaction)
+
 // ============ Client 包装器 (简化使用) ============
 
 // WSClient WebSocket 客户端包装器
@@ -254,7 +533,7 @@ func NewWSClient(config *WebSocketConfig) *WSClient {
 
 	// 自动处理消息
 	ws.OnMessage = func(msg *WSMessage) {
-		if err := handler.Handle(msg); err != nil {
+		if err := handler.Handle(msg); err != nil && ws.OnError != nil {
 			ws.OnError(err)
 		}
 	}
@@ -0,0 +1,253 @@
+package taibai
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// userMessageEvent builds a user_message frame with the given content
+func userMessageEvent(t *testing.T, content string) *WSMessage {
+	t.Helper()
+
+	payload, err := json.Marshal(UserMessage{MessageID: "m1", UserID: "u1", Content: content})
+	if err != nil {
+		t.Fatalf("Failed to marshal user message: %v", err)
+	}
+	return &WSMessage{Event: EventUserMessage, Payload: payload}
+}
+
+func TestMessageHandlerOnCommand(t *testing.T) {
+	handler := NewMessageHandler()
+
+	var got []string
+	handler.OnCommand("/deploy", func(msg *UserMessage, args []string) {
+		got = args
+	})
+	handler.OnDefaultCommand(func(msg *UserMessage, args []string) {
+		t.Errorf("Expected default handler not to be called, got %v", args)
+	})
+
+	if err := handler.Handle(userMessageEvent(t, `/deploy prod "release 1.2" --note 'two words' "say \"hi\""`)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	want := []string{"prod", "release 1.2", "--note", "two words", `say "hi"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected args %q, got %q", want, got)
+	}
+}
+
+func TestMessageHandlerCommandNoMatch(t *testing.T) {
+	handler := NewMessageHandler()
+
+	called := false
+	handler.OnCommand("/deploy", func(msg *UserMessage, args []string) {
+		called = true
+	})
+
+	for _, content := range []string{"hello /deploy", "/deployment now", ""} {
+		if err := handler.Handle(userMessageEvent(t, content)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if called {
+		t.Error("Expected /deploy handler not to be called")
+	}
+
+	var fallback []string
+	handler.OnDefaultCommand(func(msg *UserMessage, args []string) {
+		fallback = args
+	})
+	if err := handler.Handle(userMessageEvent(t, "/status web")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(fallback, []string{"/status", "web"}) {
+		t.Errorf("Expected default handler args [/status web], got %q", fallback)
+	}
+	fallback = nil
+	for _, content := range []string{"hello world", "hello /deploy", "!status"} {
+		if err := handler.Handle(userMessageEvent(t, content)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if fallback != nil {
+			t.Errorf("Expected default handler not to be called for %q, got %q", content, fallback)
+			fallback = nil
+		}
+	}
+}
+
+func TestMessageHandlerMiddleware(t *testing.T) {
+	handler := NewMessageHandler()
+
+	var order []string
+	count := 0
+	handler.Use(func(next HandlerFunc) HandlerFunc {
+		return func(wsMsg *WSMessage) error {
+			count++
+			order = append(order, "outer")
+			return next(wsMsg)
+		}
+	})
+	handler.Use(RecoverMiddleware())
+	handler.Use(func(next HandlerFunc) HandlerFunc {
+		return func(wsMsg *WSMessage) error {
+			order = append(order, "inner")
+			return next(wsMsg)
+		}
+	})
+	handler.OnUserMessage(func(msg *UserMessage) {
+		if msg.Content == "boom" {
+			panic("deliberate")
+		}
+	})
+
+	if err := handler.Handle(userMessageEvent(t, "hello")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := handler.Handle(userMessageEvent(t, "boom")); err == nil {
+		t.Error("Expected the recovered panic to be returned as an error")
+	}
+
+	if count != 2 {
+		t.Errorf("Expected middleware to run 2 times, got %d", count)
+	}
+	if !reflect.DeepEqual(order, []string{"outer", "inner", "outer", "inner"}) {
+		t.Errorf("Expected middleware in registration order, got %v", order)
+	}
+}
+
+func TestMessageHandlerErrors(t *testing.T) {
+	handler := NewMessageHandler()
+
+	errFirst := errors.New("first failed")
+	errSecond := errors.New("second failed")
+	called := 0
+	handler.OnUserMessageErr(func(msg *UserMessage) error {
+		called++
+		return errFirst
+	})
+	handler.OnUserMessage(func(msg *UserMessage) {
+		called++
+	})
+	handler.OnUserMessageErr(func(msg *UserMessage) error {
+		called++
+		return errSecond
+	})
+
+	err := handler.Handle(userMessageEvent(t, "hello"))
+	if !errors.Is(err, errFirst) || !errors.Is(err, errSecond) {
+		t.Errorf("Expected both handler errors, got %v", err)
+	}
+	if called != 3 {
+		t.Errorf("Expected all 3 handlers to run, got %d", called)
+	}
+}
+
+func TestMessageHandlerExportedSlices(t *testing.T) {
+	handler := NewMessageHandler()
+
+	var order []string
+	handler.UserMessageHandlers = append(handler.UserMessageHandlers, func(msg *UserMessage) {
+		order = append(order, "plain")
+	})
+	handler.OnUserMessageErr(func(msg *UserMessage) error {
+		order = append(order, "err")
+		return nil
+	})
+
+	if err := handler.Handle(userMessageEvent(t, "hello")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(order, []string{"plain", "err"}) {
+		t.Errorf("Expected plain handlers before error handlers, got %v", order)
+	}
+}
+
+func TestMessageHandlerErrorFrame(t *testing.T) {
+	handler := NewMessageHandler()
+	handler.OnSystem(func(event string, data json.RawMessage) {
+		t.Errorf("Expected error frame not to reach system handlers, got %s", event)
+	})
+
+	err := handler.Handle(&WSMessage{
+		Type:    "event",
+		Event:   EventError,
+		Payload: json.RawMessage(`{"code":4001,"message":"invalid subscription"}`),
+	})
+	var wsErr *WSError
+	if !errors.As(err, &wsErr) {
+		t.Fatalf("Expected *WSError, got %v", err)
+	}
+	if wsErr.Code != 4001 {
+		t.Errorf("Expected code 4001, got %d", wsErr.Code)
+	}
+}
+
+func TestWSClientHandlerErrorWithoutOnError(t *testing.T) {
+	server := newPushWSServer(t,
+		`{"type":"event","event":"user_message","payload":{"content":"one"}}`,
+		`{"type":"event","event":"user_message","payload":{"content":"two"}}`,
+	)
+
+	client := NewWSClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	handled := make(chan string, 2)
+	client.OnUserMessageErr(func(msg *UserMessage) error {
+		handled <- msg.Content
+		return errors.New("handler failed")
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	defer client.Disconnect()
+
+	// OnError is nil; the failing handler must not crash the read loop
+	for _, want := range []string{"one", "two"} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Errorf("Expected message '%s', got '%s'", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the handler")
+		}
+	}
+}
+
+func TestMessageHandlerOnReaction(t *testing.T) {
+	handler := NewMessageHandler()
+
+	var got *Reaction
+	handler.OnReaction(func(reaction *Reaction) {
+		got = reaction
+	})
+	handler.OnSystem(func(event string, data json.RawMessage) {
+		t.Errorf("Expected reaction not to reach system handlers, got %s", event)
+	})
+
+	err := handler.Handle(&WSMessage{
+		Event:   EventReaction,
+		Payload: json.RawMessage(`{"event_id":"$r1","target_event_id":"$m1","key":"👍","user_id":"u1"}`),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got == nil {
+		t.Fatal("Expected reaction handler to be called")
+	}
+	if got.TargetEventID != "$m1" {
+		t.Errorf("Expected target event ID '$m1', got '%s'", got.TargetEventID)
+	}
+	if got.Key != "👍" {
+		t.Errorf("Expected key '👍', got '%s'", got.Key)
+	}
+	if got.Timestamp == 0 {
+		t.Error("Expected missing timestamp to be filled in")
+	}
+}
@@ -0,0 +1,193 @@
+package taibai
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mediaPrefix is the path prefix of content repository endpoints
+const mediaPrefix = "/_matrix/media/r0"
+
+// MediaAPI handles uploads to and downloads from the content repository
+type MediaAPI struct {
+	client *Client
+
+	// cacheSize bounds the upload cache; 0 disables it
+	cacheSize int
+	cache     map[uploadCacheKey]*list.Element
+	cacheLRU  *list.List // of *uploadCacheEntry, most recently used first
+	cacheMu   sync.Mutex
+}
+
+// uploadCacheKey identifies an upload by its content and the metadata the
+// server stores with it
+type uploadCacheKey struct {
+	sum         [sha256.Size]byte
+	contentType string
+	filename    string
+}
+
+// uploadCacheEntry is a remembered upload
+type uploadCacheEntry struct {
+	key        uploadCacheKey
+	contentURI string
+}
+
+// UploadResponse represents the response from uploading media
+type UploadResponse struct {
+	// ContentURI is the mxc:// URI of the uploaded content
+	ContentURI string `json:"content_uri"`
+}
+
+// Upload streams r to the content repository and returns its mxc:// URI.
+// The filename is optional; contentType defaults to application/octet-stream.
+func (m *MediaAPI) Upload(ctx context.Context, r io.Reader, filename, contentType string) (*UploadResponse, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	var query map[string]string
+	if filename != "" {
+		query = map[string]string{"filename": filename}
+	}
+
+	// With the cache enabled the content is buffered to hash it, and identical
+	// bytes with the same content type and filename are answered from the
+	// cache without a request
+	key := uploadCacheKey{contentType: contentType, filename: filename}
+	if m.cacheSize > 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upload: %w", err)
+		}
+		key.sum = sha256.Sum256(data)
+		if uri, ok := m.cachedUpload(key); ok {
+			return &UploadResponse{ContentURI: uri}, nil
+		}
+		r = bytes.NewReader(data)
+	}
+
+	result := &UploadResponse{}
+	err := m.client.doJSON(ctx, &Request{
+		Method:  http.MethodPost,
+		Path:    mediaPrefix + "/upload",
+		Body:    r,
+		Query:   query,
+		Headers: map[string]string{"Content-Type": contentType},
+	}, result)
+	if err != nil {
+		return nil, err
+	}
+	if m.cacheSize > 0 && result.ContentURI != "" {
+		m.cacheUpload(key, result.ContentURI)
+	}
+	return result, nil
+}
+
+// cachedUpload looks up the URI of earlier uploaded content and marks it
+// recently used
+func (m *MediaAPI) cachedUpload(key uploadCacheKey) (string, bool) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	elem, ok := m.cache[key]
+	if !ok {
+		return "", false
+	}
+	m.cacheLRU.MoveToFront(elem)
+	return elem.Value.(*uploadCacheEntry).contentURI, true
+}
+
+// cacheUpload remembers an upload, evicting the least recently used entry
+// once the cache is full
+func (m *MediaAPI) cacheUpload(key uploadCacheKey, contentURI string) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	if m.cache == nil {
+		m.cache = make(map[uploadCacheKey]*list.Element)
+		m.cacheLRU = list.New()
+	}
+	if elem, ok := m.cache[key]; ok {
+		elem.Value.(*uploadCacheEntry).contentURI = contentURI
+		m.cacheLRU.MoveToFront(elem)
+		return
+	}
+
+	m.cache[key] = m.cacheLRU.PushFront(&uploadCacheEntry{key: key, contentURI: contentURI})
+	for m.cacheLRU.Len() > m.cacheSize {
+		oldest := m.cacheLRU.Back()
+		m.cacheLRU.Remove(oldest)
+		delete(m.cache, oldest.Value.(*uploadCacheEntry).key)
+	}
+}
+
+// ParseMXC splits an mxc://server/mediaID URI into its server name and
+// media ID
+func ParseMXC(uri string) (server, mediaID string, err error) {
+	rest, ok := strings.CutPrefix(uri, "mxc://")
+	if !ok {
+		return "", "", fmt.Errorf("invalid mxc URI %q: missing mxc:// scheme", uri)
+	}
+
+	server, mediaID, ok = strings.Cut(rest, "/")
+	if !ok || server == "" || mediaID == "" || strings.Contains(mediaID, "/") {
+		return "", "", fmt.Errorf("invalid mxc URI %q: expected mxc://server/mediaID", uri)
+	}
+	return server, mediaID, nil
+}
+
+// Download fetches the content behind an mxc:// URI and returns it together
+// with its content type. The caller must close the returned reader.
+func (m *MediaAPI) Download(ctx context.Context, mxcURI string) (io.ReadCloser, string, error) {
+	server, mediaID, err := ParseMXC(mxcURI)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return m.fetch(ctx, mediaPrefix+"/download/"+pathEscape(server)+"/"+pathEscape(mediaID), nil)
+}
+
+// Thumbnail fetches a thumbnail of the content behind an mxc:// URI. The
+// method is "scale" (default) or "crop". The caller must close the returned
+// reader.
+func (m *MediaAPI) Thumbnail(ctx context.Context, mxcURI string, width, height int, method string) (io.ReadCloser, string, error) {
+	server, mediaID, err := ParseMXC(mxcURI)
+	if err != nil {
+		return nil, "", err
+	}
+	if method == "" {
+		method = "scale"
+	}
+
+	query := map[string]string{
+		"width":  strconv.Itoa(width),
+		"height": strconv.Itoa(height),
+		"method": method,
+	}
+	return m.fetch(ctx, mediaPrefix+"/thumbnail/"+pathEscape(server)+"/"+pathEscape(mediaID), query)
+}
+
+// fetch GETs raw content from the content repository. The body is streamed,
+// not buffered.
+func (m *MediaAPI) fetch(ctx context.Context, path string, query map[string]string) (io.ReadCloser, string, error) {
+	resp, err := m.client.do(ctx, &Request{
+		Method:  http.MethodGet,
+		Path:    path,
+		Query:   query,
+		Headers: map[string]string{"Accept": "*/*"},
+		Stream:  true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Stream, resp.Headers.Get("Content-Type"), nil
+}
@@ -0,0 +1,300 @@
+package taibai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMediaUpload(t *testing.T) {
+	var path, contentType, filename string
+	var body []byte
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		contentType = req.Header.Get("Content-Type")
+		filename = req.URL.Query().Get("filename")
+		body, _ = io.ReadAll(req.Body)
+		return newMockResponse(200, map[string]string{
+			"content_uri": "mxc://localhost/" + string(body),
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Media = &MediaAPI{client: client}
+
+	resp, err := client.Media.Upload(context.Background(), strings.NewReader("abc123"), "cat.png", "image/png")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/media/r0/upload" {
+		t.Errorf("Expected path '/_matrix/media/r0/upload', got '%s'", path)
+	}
+	if contentType != "image/png" {
+		t.Errorf("Expected Content-Type 'image/png', got '%s'", contentType)
+	}
+	if filename != "cat.png" {
+		t.Errorf("Expected filename 'cat.png', got '%s'", filename)
+	}
+	if string(body) != "abc123" {
+		t.Errorf("Expected raw body 'abc123', got '%s'", string(body))
+	}
+	if resp.ContentURI != "mxc://localhost/abc123" {
+		t.Errorf("Expected content URI 'mxc://localhost/abc123', got '%s'", resp.ContentURI)
+	}
+}
+
+func TestMediaUploadCache(t *testing.T) {
+	requests := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		body, _ := io.ReadAll(req.Body)
+		return newMockResponse(200, map[string]string{
+			"content_uri": "mxc://localhost/" + string(body),
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Media = &MediaAPI{client: client, cacheSize: 1}
+	ctx := context.Background()
+
+	first, err := client.Media.Upload(ctx, strings.NewReader("same"), "a.txt", "text/plain")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := client.Media.Upload(ctx, strings.NewReader("same"), "a.txt", "text/plain")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 upload request, got %d", requests)
+	}
+	if second.ContentURI != first.ContentURI {
+		t.Errorf("Expected cached URI '%s', got '%s'", first.ContentURI, second.ContentURI)
+	}
+
+	// A different upload evicts the only entry, so "same" is uploaded again
+	if _, err := client.Media.Upload(ctx, strings.NewReader("other"), "", ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := client.Media.Upload(ctx, strings.NewReader("same"), "", ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 upload requests after eviction, got %d", requests)
+	}
+}
+
+func TestMediaUploadCacheMetadata(t *testing.T) {
+	var types []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		types = append(types, req.Header.Get("Content-Type")+" "+req.URL.Query().Get("filename"))
+		return newMockResponse(200, map[string]string{
+			"content_uri": "mxc://localhost/" + strconv.Itoa(len(types)),
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Media = &MediaAPI{client: client, cacheSize: 10}
+	ctx := context.Background()
+
+	// The same bytes under different metadata are separate uploads
+	uploads := []struct{ filename, contentType string }{
+		{"a.txt", "text/plain"},
+		{"a.txt", "text/markdown"},
+		{"b.txt", "text/plain"},
+	}
+	seen := make(map[string]bool)
+	for _, upload := range uploads {
+		resp, err := client.Media.Upload(ctx, strings.NewReader("same"), upload.filename, upload.contentType)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if seen[resp.ContentURI] {
+			t.Errorf("Expected %s %s not to reuse %s", upload.contentType, upload.filename, resp.ContentURI)
+		}
+		seen[resp.ContentURI] = true
+	}
+	if len(types) != 3 {
+		t.Errorf("Expected 3 upload requests, got %v", types)
+	}
+}
+
+func TestParseMXC(t *testing.T) {
+	server, mediaID, err := ParseMXC("mxc://localhost/abc123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if server != "localhost" || mediaID != "abc123" {
+		t.Errorf("Expected 'localhost'/'abc123', got '%s'/'%s'", server, mediaID)
+	}
+
+	invalid := []string{
+		"https://localhost/abc123",
+		"mxc://localhost",
+		"mxc:///abc123",
+		"mxc://localhost/",
+		"mxc://localhost/abc/123",
+	}
+	for _, uri := range invalid {
+		if _, _, err := ParseMXC(uri); err == nil {
+			t.Errorf("Expected error for '%s'", uri)
+		}
+	}
+}
+
+func TestMediaDownload(t *testing.T) {
+	var path string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": []string{"image/png"}},
+			Body:       io.NopCloser(strings.NewReader("png-bytes")),
+		}, nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Media = &MediaAPI{client: client}
+
+	body, contentType, err := client.Media.Download(context.Background(), "mxc://localhost/abc123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer body.Close()
+
+	if path != "/_matrix/media/r0/download/localhost/abc123" {
+		t.Errorf("Expected path '/_matrix/media/r0/download/localhost/abc123', got '%s'", path)
+	}
+	if contentType != "image/png" {
+		t.Errorf("Expected content type 'image/png', got '%s'", contentType)
+	}
+	data, _ := io.ReadAll(body)
+	if string(data) != "png-bytes" {
+		t.Errorf("Expected body 'png-bytes', got '%s'", string(data))
+	}
+
+	if _, _, err := client.Media.Download(context.Background(), "not-an-mxc"); err == nil {
+		t.Error("Expected error for invalid mxc URI")
+	}
+}
+
+// trackingBody records how much of a response body has been read
+type trackingBody struct {
+	io.Reader
+	read   int
+	closed bool
+}
+
+func (b *trackingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestMediaDownloadStreams(t *testing.T) {
+	body := &trackingBody{Reader: strings.NewReader(strings.Repeat("x", 1<<20))}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": []string{"application/octet-stream"}},
+			Body:       body,
+		}, nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Media = &MediaAPI{client: client}
+
+	reader, _, err := client.Media.Download(context.Background(), "mxc://localhost/big")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if body.read != 0 {
+		t.Errorf("Expected the body to be left unread, %d bytes were buffered", body.read)
+	}
+
+	n, _ := io.Copy(io.Discard, reader)
+	if n != 1<<20 {
+		t.Errorf("Expected %d bytes, got %d", 1<<20, n)
+	}
+	reader.Close()
+	if !body.closed {
+		t.Error("Expected closing the reader to close the response body")
+	}
+}
+
+func TestMediaThumbnail(t *testing.T) {
+	var path string
+	var query url.Values
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		query = req.URL.Query()
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": []string{"image/jpeg"}},
+			Body:       io.NopCloser(strings.NewReader("thumb")),
+		}, nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Media = &MediaAPI{client: client}
+
+	body, contentType, err := client.Media.Thumbnail(context.Background(), "mxc://localhost/abc123", 64, 32, "crop")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer body.Close()
+
+	if path != "/_matrix/media/r0/thumbnail/localhost/abc123" {
+		t.Errorf("Expected thumbnail path, got '%s'", path)
+	}
+	if query.Get("width") != "64" || query.Get("height") != "32" || query.Get("method") != "crop" {
+		t.Errorf("Expected width=64 height=32 method=crop, got %v", query)
+	}
+	if contentType != "image/jpeg" {
+		t.Errorf("Expected content type 'image/jpeg', got '%s'", contentType)
+	}
+
+	// The method defaults to scale
+	scaled, _, err := client.Media.Thumbnail(context.Background(), "mxc://localhost/abc123", 64, 32, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	scaled.Close()
+	if query.Get("method") != "scale" {
+		t.Errorf("Expected default method 'scale', got '%s'", query.Get("method"))
+	}
+}
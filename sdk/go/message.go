@@ -2,12 +2,189 @@ package taibai
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// FormatHTML is the Matrix format identifier for HTML formatted bodies
+const FormatHTML = "org.matrix.custom.html"
+
+// Room event types
+const (
+	EventTypeRoomMessage = "m.room.message"
+	EventTypeReaction    = "m.reaction"
+)
+
+// Relation types used in m.relates_to
+const (
+	RelTypeAnnotation = "m.annotation"
+	RelTypeReplace    = "m.replace"
+)
+
+// Mentions is the m.mentions block that controls notifications
+type Mentions struct {
+	// UserIDs are the users mentioned by the message
+	UserIDs []string `json:"user_ids,omitempty"`
+
+	// Room indicates an @room mention notifying everyone
+	Room bool `json:"room,omitempty"`
+}
+
+// MessageContent is the content of an m.room.message event
+type MessageContent struct {
+	// MessageType is the type of message (e.g., "m.text")
+	MessageType string `json:"msgtype"`
+
+	// Body is the plain text body
+	Body string `json:"body"`
+
+	// Format is the format of FormattedBody (optional)
+	Format string `json:"format,omitempty"`
+
+	// FormattedBody is the formatted version of the body (optional)
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// RelatesTo describes the relation of an event to another event
+type RelatesTo struct {
+	// RelType is the type of the relation (e.g., RelTypeAnnotation)
+	RelType string `json:"rel_type,omitempty"`
+
+	// EventID is the event being related to
+	EventID string `json:"event_id,omitempty"`
+
+	// Key is the annotation key, such as the reaction emoji
+	Key string `json:"key,omitempty"`
+}
+
 // MessageAPI handles message-related operations
 type MessageAPI struct {
 	client *Client
+
+	// ordered enables per-room serialization of SendMessage
+	ordered   bool
+	roomLocks keyedMutex
+
+	// aliases caches room IDs resolved from aliases by SendMessage for
+	// aliasTTL (default: DefaultAliasCacheTTL)
+	aliases  map[string]cachedAlias
+	aliasTTL time.Duration
+	aliasMu  sync.Mutex
+}
+
+// DefaultAliasCacheTTL is how long SendMessage trusts a resolved alias
+// before resolving it again, so a repointed alias is picked up
+const DefaultAliasCacheTTL = 5 * time.Minute
+
+// maxCachedAliases bounds the alias cache
+const maxCachedAliases = 256
+
+// cachedAlias is a resolved alias and when it must be resolved again
+type cachedAlias struct {
+	roomID  string
+	expires time.Time
+}
+
+// resolveRoomID returns the room ID of a #alias:server, caching the result
+// for the alias TTL; room IDs are returned unchanged
+func (m *MessageAPI) resolveRoomID(ctx context.Context, roomIDOrAlias string) (string, error) {
+	if !strings.HasPrefix(roomIDOrAlias, "#") {
+		return roomIDOrAlias, nil
+	}
+
+	now := time.Now()
+	m.aliasMu.Lock()
+	cached, ok := m.aliases[roomIDOrAlias]
+	m.aliasMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.roomID, nil
+	}
+
+	resp, err := m.client.Room.ResolveAlias(ctx, roomIDOrAlias)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve alias %s: %w", roomIDOrAlias, err)
+	}
+
+	ttl := m.aliasTTL
+	if ttl <= 0 {
+		ttl = DefaultAliasCacheTTL
+	}
+
+	m.aliasMu.Lock()
+	if m.aliases == nil {
+		m.aliases = make(map[string]cachedAlias)
+	}
+	if len(m.aliases) >= maxCachedAliases {
+		for alias, entry := range m.aliases {
+			if !now.Before(entry.expires) {
+				delete(m.aliases, alias)
+			}
+		}
+		if len(m.aliases) >= maxCachedAliases {
+			m.aliases = make(map[string]cachedAlias)
+		}
+	}
+	m.aliases[roomIDOrAlias] = cachedAlias{roomID: resp.RoomID, expires: now.Add(ttl)}
+	m.aliasMu.Unlock()
+	return resp.RoomID, nil
+}
+
+// ForgetAlias drops a cached alias resolution, e.g. after the alias was
+// moved to another room, so the next send resolves it again
+func (m *MessageAPI) ForgetAlias(alias string) {
+	m.aliasMu.Lock()
+	delete(m.aliases, alias)
+	m.aliasMu.Unlock()
+}
+
+// keyedMutex serializes callers sharing a key in FIFO order while letting
+// different keys proceed in parallel
+type keyedMutex struct {
+	mu    sync.Mutex
+	tails map[string]chan struct{}
+}
+
+// Lock waits for all earlier holders of key and returns the unlock function.
+// If ctx ends first it returns ctx's error; its place in the queue is then
+// released once the earlier holders finish, so later callers keep their order.
+func (k *keyedMutex) Lock(ctx context.Context, key string) (func(), error) {
+	k.mu.Lock()
+	if k.tails == nil {
+		k.tails = make(map[string]chan struct{})
+	}
+	prev := k.tails[key]
+	done := make(chan struct{})
+	k.tails[key] = done
+	k.mu.Unlock()
+
+	unlock := func() {
+		k.mu.Lock()
+		if k.tails[key] == done {
+			delete(k.tails, key)
+		}
+		k.mu.Unlock()
+		close(done)
+	}
+
+	if prev != nil {
+		select {
+		case <-prev:
+		case <-ctx.Done():
+			go func() {
+				<-prev
+				unlock()
+			}()
+			return nil, ctx.Err()
+		}
+	}
+
+	return unlock, nil
 }
 
 // SendMessageRequest represents a message to be sent
@@ -21,18 +198,33 @@ type SendMessageRequest struct {
 	// MessageType is the type of message (e.g., "text", "html", "m.image")
 	MessageType string `json:"msgtype,omitempty"`
 
-	// Format is the format of the message (e.g., "plain", "html")
+	// Format is the format of FormattedBody (e.g., "plain", FormatHTML)
 	Format string `json:"format,omitempty"`
 
 	// Body is the alternative plain text body
 	Body string `json:"body,omitempty"`
 
+	// FormattedBody is the formatted (e.g., HTML) version of the message
+	FormattedBody string `json:"formatted_body,omitempty"`
+
+	// Mentions lists who the message intentionally notifies (optional)
+	Mentions *Mentions `json:"m.mentions,omitempty"`
+
+	// RelatesTo relates this message to another event (optional)
+	RelatesTo *RelatesTo `json:"m.relates_to,omitempty"`
+
+	// NewContent is the replacement content when editing a message (optional)
+	NewContent *MessageContent `json:"m.new_content,omitempty"`
+
 	// URL is the URL for media messages
 	URL string `json:"url,omitempty"`
 
 	// Info is the encryption info for media
 	Info *EncryptionInfo `json:"info,omitempty"`
 
+	// File describes encrypted media; it replaces URL for encrypted rooms
+	File *EncryptedFile `json:"file,omitempty"`
+
 	// Sender is the sender of the message (optional, defaults to authenticated user)
 	Sender string `json:"sender,omitempty"`
 
@@ -55,6 +247,74 @@ type EncryptionInfo struct {
 	Version string `json:"v,omitempty"`
 }
 
+// EncryptedFile describes an encrypted media file (the "file" object of
+// encrypted m.image, m.file, etc. messages)
+type EncryptedFile struct {
+	// URL is the mxc:// URI of the encrypted content
+	URL string `json:"url"`
+
+	// Key is the AES-CTR key as a JSON Web Key
+	Key JWK `json:"key"`
+
+	// IV is the unpadded base64 initialization vector
+	IV string `json:"iv"`
+
+	// Hashes maps algorithm names to unpadded base64 hashes of the ciphertext
+	Hashes map[string]string `json:"hashes"`
+
+	// Version is the encrypted attachment format version
+	Version string `json:"v"`
+}
+
+// JWK is a JSON Web Key holding the symmetric key of an EncryptedFile
+type JWK struct {
+	// Kty is the key type, always "oct"
+	Kty string `json:"kty"`
+
+	// KeyOps lists the permitted operations, "encrypt" and "decrypt"
+	KeyOps []string `json:"key_ops"`
+
+	// Alg is the algorithm, always "A256CTR"
+	Alg string `json:"alg"`
+
+	// K is the unpadded base64url-encoded key
+	K string `json:"k"`
+
+	// Ext marks the key as extractable, always true
+	Ext bool `json:"ext"`
+}
+
+// NewEncryptedFile builds the EncryptedFile for content uploaded to url and
+// encrypted with AES-256-CTR using key (32 bytes) and iv (16 bytes).
+// ciphertextSHA256 is the SHA-256 digest of the uploaded ciphertext.
+func NewEncryptedFile(url string, key, iv, ciphertextSHA256 []byte) (*EncryptedFile, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key length %d: expected 32 bytes", len(key))
+	}
+	if len(iv) != 16 {
+		return nil, fmt.Errorf("invalid iv length %d: expected 16 bytes", len(iv))
+	}
+
+	return &EncryptedFile{
+		URL: url,
+		Key: JWK{
+			Kty:    "oct",
+			KeyOps: []string{"encrypt", "decrypt"},
+			Alg:    "A256CTR",
+			K:      base64.RawURLEncoding.EncodeToString(key),
+			Ext:    true,
+		},
+		IV:      base64.RawStdEncoding.EncodeToString(iv),
+		Hashes:  map[string]string{"sha256": base64.RawStdEncoding.EncodeToString(ciphertextSHA256)},
+		Version: "v2",
+	}, nil
+}
+
+// KeyBytes decodes the raw key of the JWK
+func (k JWK) KeyBytes() ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(k.K)
+}
+
 // SendMessageResponse represents the response from sending a message
 type SendMessageResponse struct {
 	// EventID is the unique identifier of the sent message
@@ -91,8 +351,20 @@ type MessageEvent struct {
 	Content map[string]interface{} `json:"content,omitempty"`
 }
 
+// Permalink returns a matrix.to link to the event. via lists servers that
+// can route to the room.
+func (e *MessageEvent) Permalink(via []string) string {
+	return permalink(e.RoomID, e.EventID, via)
+}
+
 // SendMessage sends a message to a room
 func (m *MessageAPI) SendMessage(ctx context.Context, req *SendMessageRequest) (*SendMessageResponse, error) {
+	// Address rooms by alias as well as by ID
+	roomID, err := m.resolveRoomID(ctx, req.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Set default message type
 	if req.MessageType == "" {
 		req.MessageType = "m.text"
@@ -108,8 +380,25 @@ func (m *MessageAPI) SendMessage(ctx context.Context, req *SendMessageRequest) (
 		req.Body = req.Content
 	}
 
+	// Send the resolved room ID without rewriting the caller's request
+	content := *req
+	content.RoomID = roomID
+	return m.SendEvent(ctx, roomID, EventTypeRoomMessage, &content)
+}
+
+// SendEvent sends an event of an arbitrary type with the given content to a room
+func (m *MessageAPI) SendEvent(ctx context.Context, roomID, eventType string, content interface{}) (*SendMessageResponse, error) {
+	// Keep sends to the same room in submission order
+	if m.ordered {
+		unlock, err := m.roomLocks.Lock(ctx, roomID)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
 	result := &SendMessageResponse{}
-	err := m.client.POST(ctx, "/_matrix/client/r0/rooms/"+req.RoomID+"/send/m.room.message", req, result)
+	err := m.client.POST(ctx, m.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/send/"+pathEscape(eventType), content, result)
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +406,19 @@ func (m *MessageAPI) SendMessage(ctx context.Context, req *SendMessageRequest) (
 	return result, nil
 }
 
+// SendReaction reacts to targetEventID with the given key (usually an emoji)
+// and returns the event ID of the reaction
+func (m *MessageAPI) SendReaction(ctx context.Context, roomID, targetEventID, emoji string) (*SendMessageResponse, error) {
+	content := map[string]interface{}{
+		"m.relates_to": &RelatesTo{
+			RelType: RelTypeAnnotation,
+			EventID: targetEventID,
+			Key:     emoji,
+		},
+	}
+	return m.SendEvent(ctx, roomID, EventTypeReaction, content)
+}
+
 // SendTextMessage sends a plain text message to a room
 func (m *MessageAPI) SendTextMessage(ctx context.Context, roomID, content string) (*SendMessageResponse, error) {
 	return m.SendMessage(ctx, &SendMessageRequest{
@@ -127,14 +429,47 @@ func (m *MessageAPI) SendTextMessage(ctx context.Context, roomID, content string
 	})
 }
 
-// SendHTMLMessage sends an HTML message to a room
-func (m *MessageAPI) SendHTMLMessage(ctx context.Context, roomID, content, html string) (*SendMessageResponse, error) {
+// SendMentionMessage sends a text message that notifies the given users,
+// and the whole room when room is true
+func (m *MessageAPI) SendMentionMessage(ctx context.Context, roomID, content string, userIDs []string, room bool) (*SendMessageResponse, error) {
 	return m.SendMessage(ctx, &SendMessageRequest{
 		RoomID:      roomID,
 		Content:     content,
-		Body:        content,
-		Format:      "html",
 		MessageType: "m.text",
+		Mentions: &Mentions{
+			UserIDs: userIDs,
+			Room:    room,
+		},
+	})
+}
+
+// SendHTMLMessage sends an HTML message to a room, with content as the plain text fallback
+func (m *MessageAPI) SendHTMLMessage(ctx context.Context, roomID, content, html string) (*SendMessageResponse, error) {
+	return m.SendMessage(ctx, &SendMessageRequest{
+		RoomID:        roomID,
+		Content:       content,
+		Body:          content,
+		Format:        FormatHTML,
+		FormattedBody: html,
+		MessageType:   "m.text",
+	})
+}
+
+// EditMessage replaces the content of a previously sent text message. The
+// top-level body carries the "* " fallback for clients without edit support.
+func (m *MessageAPI) EditMessage(ctx context.Context, roomID, targetEventID, newContent string) (*SendMessageResponse, error) {
+	return m.SendMessage(ctx, &SendMessageRequest{
+		RoomID:      roomID,
+		Content:     "* " + newContent,
+		MessageType: "m.text",
+		NewContent: &MessageContent{
+			MessageType: "m.text",
+			Body:        newContent,
+		},
+		RelatesTo: &RelatesTo{
+			RelType: RelTypeReplace,
+			EventID: targetEventID,
+		},
 	})
 }
 
@@ -152,7 +487,7 @@ func (m *MessageAPI) SendImageMessage(ctx context.Context, roomID, url, info str
 
 // GetMessage retrieves a specific message from a room
 func (m *MessageAPI) GetMessage(ctx context.Context, roomID, eventID string) (*MessageEvent, error) {
-	path := "/_matrix/client/r0/rooms/" + roomID + "/event/" + eventID
+	path := m.client.apiPrefix() + "/rooms/" + pathEscape(roomID) + "/event/" + pathEscape(eventID)
 	result := &MessageEvent{}
 	err := m.client.GET(ctx, path, nil, result)
 	if err != nil {
@@ -161,14 +496,34 @@ func (m *MessageAPI) GetMessage(ctx context.Context, roomID, eventID string) (*M
 	return result, nil
 }
 
-// GetRoomMessages retrieves messages from a room
+// GetRoomMessages retrieves messages from a room, paging backwards. To fetch
+// the next page pass the End token of the previous response as from, or use
+// NextPage which also guards against duplicated boundary events.
 func (m *MessageAPI) GetRoomMessages(ctx context.Context, roomID string, limit int, from, to string) (*MessagesResponse, error) {
+	return m.GetRoomMessagesFiltered(ctx, roomID, limit, from, to, nil)
+}
+
+// RoomEventFilter restricts the events returned by GetRoomMessagesFiltered
+type RoomEventFilter struct {
+	// Types lists the event types to include
+	Types []string `json:"types,omitempty"`
+
+	// NotTypes lists the event types to exclude
+	NotTypes []string `json:"not_types,omitempty"`
+
+	// Rooms lists the rooms to include (used by Search)
+	Rooms []string `json:"rooms,omitempty"`
+}
+
+// GetRoomMessagesFiltered retrieves messages from a room like GetRoomMessages,
+// restricted by filter (optional)
+func (m *MessageAPI) GetRoomMessagesFiltered(ctx context.Context, roomID string, limit int, from, to string, filter *RoomEventFilter) (*MessagesResponse, error) {
 	query := map[string]string{
-		"limit":  "20",
-		"dir":    "b",
+		"limit": "20",
+		"dir":   "b",
 	}
 	if limit > 0 {
-		query["limit"] = string(rune(limit))
+		query["limit"] = strconv.Itoa(limit)
 	}
 	if from != "" {
 		query["from"] = from
@@ -176,9 +531,16 @@ func (m *MessageAPI) GetRoomMessages(ctx context.Context, roomID string, limit i
 	if to != "" {
 		query["to"] = to
 	}
+	if filter != nil {
+		data, err := json.Marshal(filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal filter: %w", err)
+		}
+		query["filter"] = string(data)
+	}
 
 	result := &MessagesResponse{}
-	err := m.client.GET(ctx, "/_matrix/client/r0/rooms/"+roomID+"/messages", query, result)
+	err := m.client.GET(ctx, m.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/messages", query, result)
 	if err != nil {
 		return nil, err
 	}
@@ -200,9 +562,479 @@ type MessagesResponse struct {
 	State []MessageEvent `json:"state,omitempty"`
 }
 
+// TextMessage is a decoded m.text message
+type TextMessage struct {
+	// EventID is the unique identifier of the event
+	EventID string
+
+	// Sender is the sender of the message
+	Sender string
+
+	// Body is the plain-text body
+	Body string
+
+	// FormattedBody is the formatted body, if any
+	FormattedBody string
+
+	// Timestamp is the timestamp of the event
+	Timestamp int64
+}
+
+// ImageMessage is a decoded m.image message
+type ImageMessage struct {
+	// EventID is the unique identifier of the event
+	EventID string
+
+	// Sender is the sender of the message
+	Sender string
+
+	// Body is the image description or filename
+	Body string
+
+	// URL is the mxc:// URI of the image (empty for encrypted images)
+	URL string
+
+	// File describes the image if it is encrypted
+	File *EncryptedFile
+
+	// Timestamp is the timestamp of the event
+	Timestamp int64
+}
+
+// messageContent is the subset of m.room.message content decoded by the
+// typed accessors
+type messageContent struct {
+	MessageType   string         `json:"msgtype"`
+	Body          string         `json:"body"`
+	FormattedBody string         `json:"formatted_body"`
+	URL           string         `json:"url"`
+	File          *EncryptedFile `json:"file"`
+}
+
+// messagesOfType decodes the m.room.message events of the chunk with the
+// given msgtype, skipping events whose content does not decode
+func (r *MessagesResponse) messagesOfType(msgType string, fn func(MessageEvent, *messageContent)) {
+	for _, event := range r.Chunk {
+		if event.Type != EventTypeRoomMessage {
+			continue
+		}
+		var content messageContent
+		if err := decodeContent(event.Content, &content); err != nil || content.MessageType != msgType {
+			continue
+		}
+		fn(event, &content)
+	}
+}
+
+// TextMessages returns the m.text messages of the chunk, in chunk order
+func (r *MessagesResponse) TextMessages() []TextMessage {
+	var messages []TextMessage
+	r.messagesOfType("m.text", func(event MessageEvent, content *messageContent) {
+		messages = append(messages, TextMessage{
+			EventID:       event.EventID,
+			Sender:        event.Sender,
+			Body:          content.Body,
+			FormattedBody: content.FormattedBody,
+			Timestamp:     event.Timestamp,
+		})
+	})
+	return messages
+}
+
+// ImageMessages returns the m.image messages of the chunk, in chunk order
+func (r *MessagesResponse) ImageMessages() []ImageMessage {
+	var messages []ImageMessage
+	r.messagesOfType("m.image", func(event MessageEvent, content *messageContent) {
+		messages = append(messages, ImageMessage{
+			EventID:   event.EventID,
+			Sender:    event.Sender,
+			Body:      content.Body,
+			URL:       content.URL,
+			File:      content.File,
+			Timestamp: event.Timestamp,
+		})
+	})
+	return messages
+}
+
+// NextPage fetches the page following prev, continuing from its End token.
+// Events the server repeats at the page boundary are dropped so that
+// concatenating the chunks of consecutive pages yields no duplicates. It
+// returns an empty response once prev has no End token.
+func (m *MessageAPI) NextPage(ctx context.Context, roomID string, prev *MessagesResponse, limit int) (*MessagesResponse, error) {
+	if prev.End == "" {
+		return &MessagesResponse{}, nil
+	}
+
+	next, err := m.GetRoomMessages(ctx, roomID, limit, prev.End, "")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(prev.Chunk))
+	for _, event := range prev.Chunk {
+		seen[event.EventID] = true
+	}
+	chunk := next.Chunk[:0]
+	for _, event := range next.Chunk {
+		if event.EventID != "" && seen[event.EventID] {
+			continue
+		}
+		chunk = append(chunk, event)
+	}
+	next.Chunk = chunk
+
+	return next, nil
+}
+
+// IterOptions configures IterMessages
+type IterOptions struct {
+	// Limit is the page size (defaults to 20)
+	Limit int
+
+	// From is the token to start paging backwards from (optional)
+	From string
+
+	// Filter restricts the returned events (optional)
+	Filter *RoomEventFilter
+}
+
+// MessageIterator walks a room's history page by page. Pages are fetched
+// lazily as Next advances past the buffered events.
+type MessageIterator struct {
+	api    *MessageAPI
+	ctx    context.Context
+	roomID string
+	opts   IterOptions
+
+	from    string
+	started bool
+	done    bool
+	buf     []MessageEvent
+	current MessageEvent
+	seen    map[string]bool
+	err     error
+}
+
+// IterMessages returns an iterator over the messages of a room, newest
+// first. The iteration stops when the server returns an empty page or
+// repeats a pagination token.
+func (m *MessageAPI) IterMessages(ctx context.Context, roomID string, opts *IterOptions) *MessageIterator {
+	it := &MessageIterator{api: m, ctx: ctx, roomID: roomID}
+	if opts != nil {
+		it.opts = *opts
+	}
+	it.from = it.opts.From
+	return it
+}
+
+// Next advances to the next message, fetching a new page when needed. It
+// returns false when the history is exhausted or an error occurred.
+func (it *MessageIterator) Next() bool {
+	for len(it.buf) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+
+	it.current = it.buf[0]
+	it.buf = it.buf[1:]
+	return true
+}
+
+// fetch loads the next page into the buffer, dropping events the server
+// repeats from the previous page
+func (it *MessageIterator) fetch() {
+	resp, err := it.api.GetRoomMessagesFiltered(it.ctx, it.roomID, it.opts.Limit, it.from, "", it.opts.Filter)
+	if err != nil {
+		it.err = err
+		return
+	}
+
+	if len(resp.Chunk) == 0 || resp.End == "" || (it.started && resp.End == it.from) {
+		it.done = true
+	}
+	it.started = true
+	it.from = resp.End
+
+	seen := make(map[string]bool, len(resp.Chunk))
+	for _, event := range resp.Chunk {
+		if event.EventID != "" {
+			if it.seen[event.EventID] {
+				continue
+			}
+			seen[event.EventID] = true
+		}
+		it.buf = append(it.buf, event)
+	}
+	it.seen = seen
+}
+
+// Message returns the message Next advanced to
+func (it *MessageIterator) Message() MessageEvent {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *MessageIterator) Err() error {
+	return it.err
+}
+
+// GetMessagesUntil pages backwards through a room's history until it reaches
+// stopAtEventID and returns the events newer than it in chronological order.
+// If the event is never found, the whole history is returned.
+func (m *MessageAPI) GetMessagesUntil(ctx context.Context, roomID, stopAtEventID string, pageSize int) ([]MessageEvent, error) {
+	var events []MessageEvent
+	it := m.IterMessages(ctx, roomID, &IterOptions{Limit: pageSize})
+	for it.Next() {
+		event := it.Message()
+		if event.EventID == stopAtEventID {
+			break
+		}
+		events = append(events, event)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	// Pages run newest first; flip into chronological order
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// CollectEvents pages backwards through a room's history and returns up to
+// max events of eventType, newest first. max <= 0 collects the whole
+// history. The type is filtered on the server and checked again locally.
+func (m *MessageAPI) CollectEvents(ctx context.Context, roomID, eventType string, max int) ([]MessageEvent, error) {
+	var events []MessageEvent
+	it := m.IterMessages(ctx, roomID, &IterOptions{
+		Filter: &RoomEventFilter{Types: []string{eventType}},
+	})
+	for (max <= 0 || len(events) < max) && it.Next() {
+		if event := it.Message(); event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// WaitForEvent polls the room's recent messages every pollInterval (default
+// 1 second) until match accepts one of them, and returns that event. Only
+// events newer than the room's latest event at the time of the call are
+// considered. It gives up when ctx ends.
+func (m *MessageAPI) WaitForEvent(ctx context.Context, roomID string, match func(MessageEvent) bool, pollInterval time.Duration) (*MessageEvent, error) {
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	// The first poll only records the newest existing event; later polls
+	// page backwards and stop at the newest event already checked
+	first := true
+	var seen string
+	for {
+		resp, err := m.GetRoomMessages(ctx, roomID, 0, "", "")
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+		if !first {
+			for _, event := range resp.Chunk {
+				if seen != "" && event.EventID == seen {
+					break
+				}
+				if match(event) {
+					return &event, nil
+				}
+			}
+		}
+		first = false
+		if len(resp.Chunk) > 0 {
+			seen = resp.Chunk[0].EventID
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendAndConfirm sends a message and waits until its event comes back
+// through syncer, confirming the server has processed it. The syncer must
+// be running (see Syncer.Run). It gives up after timeout (no limit if
+// timeout is 0) or when ctx ends.
+func (m *MessageAPI) SendAndConfirm(ctx context.Context, req *SendMessageRequest, syncer *Syncer, timeout time.Duration) (*MessageEvent, error) {
+	if syncer == nil {
+		return nil, fmt.Errorf("syncer is required")
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Resolve an alias up front: sync reports events by room ID
+	roomID, err := m.resolveRoomID(ctx, req.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch before sending so an echo that arrives before the send
+	// response is not missed
+	var (
+		mu     sync.Mutex
+		seen   = make(map[string]MessageEvent)
+		notify = make(chan struct{}, 1)
+	)
+	stop := syncer.watchTimeline(func(eventRoomID string, event *MessageEvent) {
+		if eventRoomID != roomID {
+			return
+		}
+		mu.Lock()
+		seen[event.EventID] = *event
+		mu.Unlock()
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	defer stop()
+
+	resp, err := m.SendMessage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		mu.Lock()
+		event, ok := seen[resp.EventID]
+		mu.Unlock()
+		if ok {
+			if event.RoomID == "" {
+				event.RoomID = roomID
+			}
+			return &event, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("event %s was not seen in sync: %w", resp.EventID, ctx.Err())
+		case <-notify:
+		}
+	}
+}
+
+// SendTyping starts or stops the typing indicator of a user in a room.
+// timeoutMs defaults to 30000 and is ignored when typing is false.
+func (m *MessageAPI) SendTyping(ctx context.Context, roomID, userID string, typing bool, timeoutMs int) error {
+	body := map[string]interface{}{
+		"typing": typing,
+	}
+	if typing {
+		if timeoutMs <= 0 {
+			timeoutMs = 30000
+		}
+		body["timeout"] = timeoutMs
+	}
+
+	path := m.client.apiPrefix() + "/rooms/" + pathEscape(roomID) + "/typing/" + pathEscape(userID)
+	return m.client.PUT(ctx, path, body, nil)
+}
+
+// SendReadReceipt marks eventID and everything before it as read
+func (m *MessageAPI) SendReadReceipt(ctx context.Context, roomID, eventID string) error {
+	path := m.client.apiPrefix() + "/rooms/" + pathEscape(roomID) + "/receipt/m.read/" + pathEscape(eventID)
+	return m.client.POST(ctx, path, map[string]string{}, nil)
+}
+
+// SetReadMarker moves the fully-read marker and, optionally, the read
+// receipt of a room. Empty event IDs are left unchanged.
+func (m *MessageAPI) SetReadMarker(ctx context.Context, roomID, fullyRead, read string) error {
+	body := map[string]string{}
+	if fullyRead != "" {
+		body["m.fully_read"] = fullyRead
+	}
+	if read != "" {
+		body["m.read"] = read
+	}
+
+	return m.client.POST(ctx, m.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/read_markers", body, nil)
+}
+
+// RelationsResponse represents a page of events relating to a parent event
+type RelationsResponse struct {
+	// Chunk contains the related events
+	Chunk []MessageEvent `json:"chunk"`
+
+	// NextBatch is the token for the next page (empty when there are no more)
+	NextBatch string `json:"next_batch,omitempty"`
+
+	// PrevBatch is the token for the previous page
+	PrevBatch string `json:"prev_batch,omitempty"`
+}
+
+// GetRelations retrieves the events relating to eventID. relType (e.g.
+// "m.annotation") and eventType (e.g. "m.reaction") are optional filters;
+// eventType is only applied together with relType. from and limit page
+// through the results.
+func (m *MessageAPI) GetRelations(ctx context.Context, roomID, eventID, relType, eventType string, from string, limit int) (*RelationsResponse, error) {
+	path := m.client.apiPrefix() + "/rooms/" + pathEscape(roomID) + "/relations/" + pathEscape(eventID)
+	if relType != "" {
+		path += "/" + pathEscape(relType)
+		if eventType != "" {
+			path += "/" + pathEscape(eventType)
+		}
+	}
+
+	query := map[string]string{}
+	if from != "" {
+		query["from"] = from
+	}
+	if limit > 0 {
+		query["limit"] = strconv.Itoa(limit)
+	}
+
+	result := &RelationsResponse{}
+	err := m.client.GET(ctx, path, query, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReportEvent reports an event to the server administrators. score ranges
+// from -100 (most offensive) to 0 (inoffensive).
+func (m *MessageAPI) ReportEvent(ctx context.Context, roomID, eventID string, score int, reason string) error {
+	if score < -100 || score > 0 {
+		return fmt.Errorf("invalid report score %d: must be between -100 and 0", score)
+	}
+
+	body := map[string]interface{}{
+		"score": score,
+	}
+	if reason != "" {
+		body["reason"] = reason
+	}
+
+	path := m.client.apiPrefix() + "/rooms/" + pathEscape(roomID) + "/report/" + pathEscape(eventID)
+	return m.client.POST(ctx, path, body, nil)
+}
+
 // RedactMessage redacts a message in a room
 func (m *MessageAPI) RedactMessage(ctx context.Context, roomID, eventID string, reason string) error {
-	path := "/_matrix/client/r0/rooms/" + roomID + "/redact/" + eventID
+	path := m.client.apiPrefix() + "/rooms/" + pathEscape(roomID) + "/redact/" + pathEscape(eventID)
 
 	body := map[string]string{}
 	if reason != "" {
@@ -211,3 +1043,93 @@ func (m *MessageAPI) RedactMessage(ctx context.Context, roomID, eventID string,
 
 	return m.client.PUT(ctx, path, body, nil)
 }
+
+// Search orderings
+const (
+	SearchOrderRank   = "rank"
+	SearchOrderRecent = "recent"
+)
+
+// SearchRequest represents a full-text search over room events
+type SearchRequest struct {
+	// SearchTerm is the text to search for
+	SearchTerm string
+
+	// Keys lists the content fields to search, e.g. "content.body" (optional)
+	Keys []string
+
+	// Filter restricts the searched events, e.g. to some rooms (optional)
+	Filter *RoomEventFilter
+
+	// OrderBy is SearchOrderRank or SearchOrderRecent (optional)
+	OrderBy string
+
+	// NextBatch is the NextBatch of a previous response, to fetch the next page
+	NextBatch string
+}
+
+// SearchResponse represents a page of search results
+type SearchResponse struct {
+	// Count is the approximate total number of results
+	Count int `json:"count"`
+
+	// Results contains the matching events
+	Results []SearchResult `json:"results"`
+
+	// Highlights lists the words the server matched, for highlighting
+	Highlights []string `json:"highlights"`
+
+	// NextBatch is the token for the next page (empty when there are no more)
+	NextBatch string `json:"next_batch,omitempty"`
+}
+
+// SearchResult represents a single search hit
+type SearchResult struct {
+	// Rank is the relevance of the result
+	Rank float64 `json:"rank"`
+
+	// Result is the matching event
+	Result MessageEvent `json:"result"`
+}
+
+// Search performs a full-text search over the events of the user's rooms
+func (m *MessageAPI) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	criteria := map[string]interface{}{
+		"search_term": req.SearchTerm,
+	}
+	if len(req.Keys) > 0 {
+		criteria["keys"] = req.Keys
+	}
+	if req.Filter != nil {
+		criteria["filter"] = req.Filter
+	}
+	if req.OrderBy != "" {
+		criteria["order_by"] = req.OrderBy
+	}
+
+	var query map[string]string
+	if req.NextBatch != "" {
+		query = map[string]string{"next_batch": req.NextBatch}
+	}
+
+	var result struct {
+		SearchCategories struct {
+			RoomEvents SearchResponse `json:"room_events"`
+		} `json:"search_categories"`
+	}
+	err := m.client.doJSON(ctx, &Request{
+		Method: http.MethodPost,
+		Path:   m.client.apiPrefix() + "/search",
+		Body: map[string]interface{}{
+			"search_categories": map[string]interface{}{
+				"room_events": criteria,
+			},
+		},
+		Query:      query,
+		Idempotent: true,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result.SearchCategories.RoomEvents, nil
+}
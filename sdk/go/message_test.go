@@ -1,11 +1,18 @@
 package taibai
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // MockMessageClient creates a client with mock HTTP for message testing
@@ -37,8 +44,8 @@ func TestSendMessage(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -74,8 +81,8 @@ func TestSendTextMessage(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -102,8 +109,8 @@ func TestSendHTMLMessage(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -118,6 +125,36 @@ func TestSendHTMLMessage(t *testing.T) {
 	}
 }
 
+func TestSendHTMLMessagePayload(t *testing.T) {
+	var payload map[string]interface{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		json.NewDecoder(req.Body).Decode(&payload)
+		return newMockResponse(200, map[string]string{"event_id": "$test-event-id"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	_, err := client.Message.SendHTMLMessage(context.Background(), "!test-room:localhost", "Hello", "<b>Hello</b>")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if payload["body"] != "Hello" {
+		t.Errorf("Expected body 'Hello', got '%v'", payload["body"])
+	}
+	if payload["formatted_body"] != "<b>Hello</b>" {
+		t.Errorf("Expected formatted_body '<b>Hello</b>', got '%v'", payload["formatted_body"])
+	}
+	if payload["format"] != FormatHTML {
+		t.Errorf("Expected format '%s', got '%v'", FormatHTML, payload["format"])
+	}
+}
+
 func TestSendImageMessage(t *testing.T) {
 	mock := &MockHTTPClient{
 		Response: newMockResponse(200, map[string]string{
@@ -130,8 +167,8 @@ func TestSendImageMessage(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -165,8 +202,8 @@ func TestGetMessage(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -213,8 +250,8 @@ func TestGetRoomMessages(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -248,8 +285,8 @@ func TestRedactMessage(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -271,8 +308,8 @@ func TestSendMessageDefaultValues(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -429,8 +466,8 @@ func TestMessageAPIErrorHandling(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -456,8 +493,8 @@ func TestMessageAPIPathConstruction(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Message:    &MessageAPI{client: client},
 	}
+	client.Message = &MessageAPI{client: client}
 
 	ctx := context.Background()
 
@@ -468,3 +505,1356 @@ func TestMessageAPIPathConstruction(t *testing.T) {
 		t.Errorf("Expected no error, got %v", err)
 	}
 }
+
+func TestSendMessageOrderedPerRoom(t *testing.T) {
+	gate := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	var first sync.Once
+
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		var body SendMessageRequest
+		json.NewDecoder(req.Body).Decode(&body)
+
+		// Hold the first send in flight so the rest queue up behind it
+		first.Do(func() { <-gate })
+
+		mu.Lock()
+		order = append(order, body.Content)
+		mu.Unlock()
+		return newMockResponse(200, map[string]string{"event_id": "$" + body.Content}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client, ordered: true}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(content string) {
+			defer wg.Done()
+			if _, err := client.Message.SendTextMessage(ctx, "!room:localhost", content); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}(strconv.Itoa(i))
+		// Give each goroutine time to queue before submitting the next
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(gate)
+	wg.Wait()
+
+	for i, content := range order {
+		if content != strconv.Itoa(i) {
+			t.Fatalf("Expected sends in submission order, got %v", order)
+		}
+	}
+}
+
+func TestSendMessageOrderedCancelWhileQueued(t *testing.T) {
+	gate := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	var first sync.Once
+
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		var body SendMessageRequest
+		json.NewDecoder(req.Body).Decode(&body)
+		first.Do(func() { <-gate })
+
+		mu.Lock()
+		order = append(order, body.Content)
+		mu.Unlock()
+		return newMockResponse(200, map[string]string{"event_id": "$" + body.Content}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client, ordered: true}
+
+	var wg sync.WaitGroup
+	send := func(ctx context.Context, content string) {
+		defer wg.Done()
+		client.Message.SendTextMessage(ctx, "!room:localhost", content)
+	}
+
+	wg.Add(1)
+	go send(context.Background(), "first")
+	time.Sleep(5 * time.Millisecond)
+
+	// A queued send gives up when its context ends, without waiting for
+	// the send in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.Message.SendTextMessage(ctx, "!room:localhost", "cancelled"); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected queued send to return when its context ended, took %v", elapsed)
+	}
+
+	wg.Add(1)
+	go send(context.Background(), "last")
+	time.Sleep(5 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+
+	if strings.Join(order, ",") != "first,last" {
+		t.Errorf("Expected [first last], got %v", order)
+	}
+}
+
+func TestSendMessageOrderedAcrossRooms(t *testing.T) {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		var body SendMessageRequest
+		json.NewDecoder(req.Body).Decode(&body)
+		started <- body.RoomID
+		<-release
+		return newMockResponse(200, map[string]string{"event_id": "$event"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client, ordered: true}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for _, roomID := range []string{"!a:localhost", "!b:localhost"} {
+		wg.Add(1)
+		go func(roomID string) {
+			defer wg.Done()
+			client.Message.SendTextMessage(ctx, roomID, "hello")
+		}(roomID)
+	}
+
+	// Both rooms must be in flight at the same time
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("Expected sends to different rooms to run in parallel")
+		}
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestGetRoomMessagesLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  string
+	}{
+		{name: "custom limit", limit: 50, want: "50"},
+		{name: "zero uses default", limit: 0, want: "20"},
+		{name: "negative uses default", limit: -1, want: "20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+				got = req.URL.Query().Get("limit")
+				return newMockResponse(200, map[string]interface{}{"chunk": []interface{}{}}), nil
+			})
+
+			client := &Client{
+				httpClient: mock,
+				baseURL:    "http://localhost:8008",
+				token:      "test-token",
+			}
+			client.Message = &MessageAPI{client: client}
+
+			_, err := client.Message.GetRoomMessages(context.Background(), "!test-room:localhost", tt.limit, "", "")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Expected limit '%s', got '%s'", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNextPageContiguous(t *testing.T) {
+	event := func(id string) map[string]interface{} {
+		return map[string]interface{}{"event_id": id, "type": "m.room.message"}
+	}
+	pages := map[string]interface{}{
+		"": map[string]interface{}{
+			"chunk": []interface{}{event("$5"), event("$4"), event("$3")},
+			"start": "t5",
+			"end":   "t3",
+		},
+		// The server repeats the boundary event $3 at the top of the next page
+		"t3": map[string]interface{}{
+			"chunk": []interface{}{event("$3"), event("$2"), event("$1")},
+			"start": "t3",
+			"end":   "t1",
+		},
+	}
+
+	var froms []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		from := req.URL.Query().Get("from")
+		froms = append(froms, from)
+		return newMockResponse(200, pages[from]), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	ctx := context.Background()
+	first, err := client.Message.GetRoomMessages(ctx, "!room:localhost", 3, "", "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := client.Message.NextPage(ctx, "!room:localhost", first, 3)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if froms[1] != "t3" {
+		t.Errorf("Expected second page to start from 't3', got '%s'", froms[1])
+	}
+
+	var ids []string
+	for _, e := range append(first.Chunk, second.Chunk...) {
+		ids = append(ids, e.EventID)
+	}
+	expected := []string{"$5", "$4", "$3", "$2", "$1"}
+	if len(ids) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, ids)
+	}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Fatalf("Expected events %v, got %v", expected, ids)
+		}
+	}
+}
+
+func TestSendTyping(t *testing.T) {
+	tests := []struct {
+		name      string
+		typing    bool
+		timeoutMs int
+		expected  map[string]interface{}
+	}{
+		{
+			name:      "start",
+			typing:    true,
+			timeoutMs: 30000,
+			expected:  map[string]interface{}{"typing": true, "timeout": float64(30000)},
+		},
+		{
+			name:      "stop",
+			typing:    false,
+			timeoutMs: 30000,
+			expected:  map[string]interface{}{"typing": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path string
+			var body map[string]interface{}
+			mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+				method = req.Method
+				path = req.URL.Path
+				json.NewDecoder(req.Body).Decode(&body)
+				return newMockResponse(200, map[string]string{}), nil
+			})
+
+			client := &Client{
+				httpClient: mock,
+				baseURL:    "http://localhost:8008",
+				token:      "test-token",
+			}
+			client.Message = &MessageAPI{client: client}
+
+			err := client.Message.SendTyping(context.Background(), "!room:localhost", "@bot:localhost", tt.typing, tt.timeoutMs)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if method != http.MethodPut {
+				t.Errorf("Expected PUT, got %s", method)
+			}
+			if path != "/_matrix/client/r0/rooms/!room:localhost/typing/@bot:localhost" {
+				t.Errorf("Unexpected path '%s'", path)
+			}
+			if len(body) != len(tt.expected) {
+				t.Fatalf("Expected body %v, got %v", tt.expected, body)
+			}
+			for key, value := range tt.expected {
+				if body[key] != value {
+					t.Errorf("Expected %s=%v, got %v", key, value, body[key])
+				}
+			}
+		})
+	}
+}
+
+func TestGetRelations(t *testing.T) {
+	tests := []struct {
+		name      string
+		relType   string
+		eventType string
+		expected  string
+	}{
+		{
+			name:     "all relations",
+			expected: "/_matrix/client/r0/rooms/!room:localhost/relations/$parent",
+		},
+		{
+			name:     "by rel type",
+			relType:  "m.thread",
+			expected: "/_matrix/client/r0/rooms/!room:localhost/relations/$parent/m.thread",
+		},
+		{
+			name:      "by rel type and event type",
+			relType:   "m.annotation",
+			eventType: "m.reaction",
+			expected:  "/_matrix/client/r0/rooms/!room:localhost/relations/$parent/m.annotation/m.reaction",
+		},
+		{
+			name:      "event type without rel type is ignored",
+			eventType: "m.reaction",
+			expected:  "/_matrix/client/r0/rooms/!room:localhost/relations/$parent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			mock := MockHTTPFunc(func(r *http.Request) (*http.Response, error) {
+				req = r
+				return newMockResponse(200, map[string]interface{}{
+					"chunk": []interface{}{
+						map[string]interface{}{"event_id": "$r1", "type": "m.reaction"},
+						map[string]interface{}{"event_id": "$r2", "type": "m.reaction"},
+					},
+					"next_batch": "page-2",
+				}), nil
+			})
+
+			client := &Client{
+				httpClient: mock,
+				baseURL:    "http://localhost:8008",
+				token:      "test-token",
+			}
+			client.Message = &MessageAPI{client: client}
+
+			resp, err := client.Message.GetRelations(context.Background(), "!room:localhost", "$parent", tt.relType, tt.eventType, "page-1", 10)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if req.URL.Path != tt.expected {
+				t.Errorf("Expected path '%s', got '%s'", tt.expected, req.URL.Path)
+			}
+			if req.URL.Query().Get("from") != "page-1" || req.URL.Query().Get("limit") != "10" {
+				t.Errorf("Unexpected pagination query '%s'", req.URL.RawQuery)
+			}
+			if len(resp.Chunk) != 2 {
+				t.Errorf("Expected 2 related events, got %d", len(resp.Chunk))
+			}
+			if resp.NextBatch != "page-2" {
+				t.Errorf("Expected next_batch 'page-2', got '%s'", resp.NextBatch)
+			}
+		})
+	}
+}
+
+func TestSendReadReceipt(t *testing.T) {
+	var method, path string
+	var body map[string]string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		method = req.Method
+		path = req.URL.Path
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	if err := client.Message.SendReadReceipt(context.Background(), "!room:localhost", "$event"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if method != http.MethodPost {
+		t.Errorf("Expected POST, got %s", method)
+	}
+	if path != "/_matrix/client/r0/rooms/!room:localhost/receipt/m.read/$event" {
+		t.Errorf("Unexpected path '%s'", path)
+	}
+	if len(body) != 0 {
+		t.Errorf("Expected empty body, got %v", body)
+	}
+}
+
+func TestSetReadMarker(t *testing.T) {
+	var path string
+	var body map[string]string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		body = nil
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	if err := client.Message.SetReadMarker(context.Background(), "!room:localhost", "$fully", "$read"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/rooms/!room:localhost/read_markers" {
+		t.Errorf("Unexpected path '%s'", path)
+	}
+	if body["m.fully_read"] != "$fully" || body["m.read"] != "$read" {
+		t.Errorf("Unexpected body %v", body)
+	}
+
+	// Omitted markers are not sent
+	if err := client.Message.SetReadMarker(context.Background(), "!room:localhost", "$fully", ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := body["m.read"]; ok {
+		t.Errorf("Expected m.read to be omitted, got %v", body)
+	}
+}
+
+func TestSendReaction(t *testing.T) {
+	var path string
+	var body struct {
+		RelatesTo *RelatesTo `json:"m.relates_to"`
+	}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"event_id": "$reaction"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	resp, err := client.Message.SendReaction(context.Background(), "!room:localhost", "$target", "👍")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if resp.EventID != "$reaction" {
+		t.Errorf("Expected event_id '$reaction', got '%s'", resp.EventID)
+	}
+	if path != "/_matrix/client/r0/rooms/!room:localhost/send/m.reaction" {
+		t.Errorf("Unexpected path '%s'", path)
+	}
+	if body.RelatesTo == nil {
+		t.Fatal("Expected m.relates_to in body")
+	}
+	if body.RelatesTo.RelType != "m.annotation" || body.RelatesTo.EventID != "$target" || body.RelatesTo.Key != "👍" {
+		t.Errorf("Unexpected relation %+v", body.RelatesTo)
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	var body map[string]interface{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"event_id": "$edit"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	resp, err := client.Message.EditMessage(context.Background(), "!room:localhost", "$original", "Deploy finished")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if resp.EventID != "$edit" {
+		t.Errorf("Expected event_id '$edit', got '%s'", resp.EventID)
+	}
+
+	if body["body"] != "* Deploy finished" {
+		t.Errorf("Expected fallback body '* Deploy finished', got '%v'", body["body"])
+	}
+
+	newContent, ok := body["m.new_content"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected m.new_content in body, got %v", body)
+	}
+	if newContent["body"] != "Deploy finished" || newContent["msgtype"] != "m.text" {
+		t.Errorf("Unexpected m.new_content %v", newContent)
+	}
+
+	relatesTo, ok := body["m.relates_to"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected m.relates_to in body, got %v", body)
+	}
+	if relatesTo["rel_type"] != "m.replace" || relatesTo["event_id"] != "$original" {
+		t.Errorf("Unexpected m.relates_to %v", relatesTo)
+	}
+}
+
+func TestSendMentionMessage(t *testing.T) {
+	var body struct {
+		Body     string    `json:"body"`
+		Mentions *Mentions `json:"m.mentions"`
+	}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"event_id": "$mention"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	users := []string{"@alice:localhost", "@bob:localhost"}
+	_, err := client.Message.SendMentionMessage(context.Background(), "!room:localhost", "Alice, Bob: build is red", users, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if body.Mentions == nil {
+		t.Fatal("Expected m.mentions in body")
+	}
+	if len(body.Mentions.UserIDs) != 2 || body.Mentions.UserIDs[0] != "@alice:localhost" || body.Mentions.UserIDs[1] != "@bob:localhost" {
+		t.Errorf("Expected mentioned users %v, got %v", users, body.Mentions.UserIDs)
+	}
+	if body.Mentions.Room {
+		t.Error("Expected room mention to be false")
+	}
+}
+
+func TestSendMessageWithoutMentions(t *testing.T) {
+	var body map[string]interface{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"event_id": "$event"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	if _, err := client.Message.SendTextMessage(context.Background(), "!room:localhost", "hello"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := body["m.mentions"]; ok {
+		t.Errorf("Expected no m.mentions block, got %v", body["m.mentions"])
+	}
+}
+
+func TestGetRoomMessagesFiltered(t *testing.T) {
+	var filter RoomEventFilter
+	var rawQuery string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		rawQuery = req.URL.RawQuery
+		json.Unmarshal([]byte(req.URL.Query().Get("filter")), &filter)
+		return newMockResponse(200, map[string]interface{}{"chunk": []interface{}{}}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	_, err := client.Message.GetRoomMessagesFiltered(context.Background(), "!room:localhost", 10, "", "", &RoomEventFilter{
+		Types: []string{"m.room.message"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(filter.Types) != 1 || filter.Types[0] != "m.room.message" {
+		t.Errorf("Expected filter types [m.room.message], got %v (query '%s')", filter.Types, rawQuery)
+	}
+	if strings.Contains(rawQuery, "{") {
+		t.Errorf("Expected filter JSON to be URL-encoded, got '%s'", rawQuery)
+	}
+}
+
+func TestMessagePathSegmentsEscaped(t *testing.T) {
+	var path string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.EscapedPath()
+		return newMockResponse(200, map[string]string{"event_id": "$event:server"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	client.Message.RedactMessage(context.Background(), "#a/b:server", "$ev/1:server", "")
+	if path != "/_matrix/client/r0/rooms/%23a%2Fb:server/redact/$ev%2F1:server" {
+		t.Errorf("Expected escaped segments, got '%s'", path)
+	}
+}
+
+func TestIterMessages(t *testing.T) {
+	pages := map[string]map[string]interface{}{
+		"": {
+			"chunk": []map[string]string{{"event_id": "$3"}, {"event_id": "$2"}},
+			"start": "t3",
+			"end":   "t2",
+		},
+		"t2": {
+			"chunk": []map[string]string{{"event_id": "$2"}, {"event_id": "$1"}},
+			"start": "t2",
+			"end":   "t1",
+		},
+		"t1": {
+			"chunk": []map[string]string{},
+			"start": "t1",
+		},
+	}
+
+	var froms []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		from := req.URL.Query().Get("from")
+		froms = append(froms, from)
+		return newMockResponse(200, pages[from]), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	it := client.Message.IterMessages(context.Background(), "!room:localhost", &IterOptions{Limit: 2})
+
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.Message().EventID)
+	}
+	if it.Err() != nil {
+		t.Fatalf("Expected no error, got %v", it.Err())
+	}
+
+	expected := []string{"$3", "$2", "$1"}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected events %v, got %v", expected, ids)
+	}
+	if strings.Join(froms, ",") != ",t2,t1" {
+		t.Errorf("Expected requests from '', 't2', 't1', got %v", froms)
+	}
+
+	// Exhausted iterators do not fetch again
+	if it.Next() {
+		t.Error("Expected Next to return false after exhaustion")
+	}
+	if len(froms) != 3 {
+		t.Errorf("Expected 3 requests, got %d", len(froms))
+	}
+}
+
+func TestCollectEvents(t *testing.T) {
+	pages := map[string]map[string]interface{}{
+		"": {
+			"chunk": []map[string]string{
+				{"event_id": "$5", "type": "m.reaction"},
+				{"event_id": "$4", "type": "m.room.message"},
+				{"event_id": "$3", "type": "m.reaction"},
+			},
+			"end": "t3",
+		},
+		"t3": {
+			"chunk": []map[string]string{
+				{"event_id": "$2", "type": "m.reaction"},
+				{"event_id": "$1", "type": "m.reaction"},
+			},
+			"end": "t1",
+		},
+	}
+
+	var froms []string
+	var filter string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		from := req.URL.Query().Get("from")
+		froms = append(froms, from)
+		filter = req.URL.Query().Get("filter")
+		return newMockResponse(200, pages[from]), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	events, err := client.Message.CollectEvents(context.Background(), "!room:localhost", "m.reaction", 3)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.EventID)
+	}
+	if strings.Join(ids, ",") != "$5,$3,$2" {
+		t.Errorf("Expected reactions [$5 $3 $2], got %v", ids)
+	}
+	if strings.Join(froms, ",") != ",t3" {
+		t.Errorf("Expected two pages fetched, got requests from %v", froms)
+	}
+	if !strings.Contains(filter, `"types":["m.reaction"]`) {
+		t.Errorf("Expected a types filter for m.reaction, got '%s'", filter)
+	}
+}
+
+func TestIterMessagesRepeatedToken(t *testing.T) {
+	requests := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return newMockResponse(200, map[string]interface{}{
+			"chunk": []map[string]string{{"event_id": "$" + strconv.Itoa(requests)}},
+			"end":   "stuck",
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	it := client.Message.IterMessages(context.Background(), "!room:localhost", nil)
+	count := 0
+	for it.Next() {
+		count++
+	}
+
+	if requests != 2 {
+		t.Errorf("Expected iteration to stop after the token repeated, got %d requests", requests)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 events, got %d", count)
+	}
+}
+
+func TestEncryptedFileJWK(t *testing.T) {
+	key := bytes.Repeat([]byte{0xfb}, 32)
+	iv := bytes.Repeat([]byte{0x01}, 16)
+	hash := bytes.Repeat([]byte{0x02}, 32)
+
+	file, err := NewEncryptedFile("mxc://localhost/encrypted", key, iv, hash)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := json.Marshal(&SendMessageRequest{MessageType: "m.image", File: file})
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+
+	var parsed struct {
+		File struct {
+			URL string                 `json:"url"`
+			Key map[string]interface{} `json:"key"`
+			IV  string                 `json:"iv"`
+			V   string                 `json:"v"`
+		} `json:"file"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+
+	jwk := parsed.File.Key
+	if jwk["kty"] != "oct" || jwk["alg"] != "A256CTR" || jwk["ext"] != true {
+		t.Errorf("Expected oct/A256CTR extractable JWK, got %v", jwk)
+	}
+	ops, _ := jwk["key_ops"].([]interface{})
+	if len(ops) != 2 || ops[0] != "encrypt" || ops[1] != "decrypt" {
+		t.Errorf("Expected key_ops [encrypt decrypt], got %v", jwk["key_ops"])
+	}
+	// base64url without padding: 0xfb bytes encode to '-' and '_' characters
+	k, _ := jwk["k"].(string)
+	if strings.ContainsAny(k, "+/=") {
+		t.Errorf("Expected unpadded base64url key, got '%s'", k)
+	}
+	if parsed.File.IV != "AQEBAQEBAQEBAQEBAQEBAQ" {
+		t.Errorf("Expected unpadded base64 iv, got '%s'", parsed.File.IV)
+	}
+	if parsed.File.URL != "mxc://localhost/encrypted" || parsed.File.V != "v2" {
+		t.Errorf("Expected url and v2 version, got %+v", parsed.File)
+	}
+
+	decoded, err := file.Key.KeyBytes()
+	if err != nil || !bytes.Equal(decoded, key) {
+		t.Errorf("Expected key to round-trip, got %x (%v)", decoded, err)
+	}
+
+	if _, err := NewEncryptedFile("mxc://localhost/encrypted", key[:16], iv, hash); err == nil {
+		t.Error("Expected error for short key")
+	}
+}
+
+func TestMessagesResponseTypedMessages(t *testing.T) {
+	var response MessagesResponse
+	err := json.Unmarshal([]byte(`{
+		"chunk": [
+			{"event_id": "$1", "type": "m.room.message", "sender": "@alice:localhost", "timestamp": 1000,
+			 "content": {"msgtype": "m.text", "body": "hello"}},
+			{"event_id": "$2", "type": "m.room.message", "sender": "@bob:localhost", "timestamp": 2000,
+			 "content": {"msgtype": "m.image", "body": "cat.png", "url": "mxc://localhost/cat"}},
+			{"event_id": "$3", "type": "m.room.member", "sender": "@carol:localhost",
+			 "content": {"membership": "join"}},
+			{"event_id": "$4", "type": "m.room.message", "sender": "@alice:localhost", "timestamp": 3000,
+			 "content": {"msgtype": "m.text", "body": "bye"}}
+		]
+	}`), &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	texts := response.TextMessages()
+	if len(texts) != 2 {
+		t.Fatalf("Expected 2 text messages, got %d", len(texts))
+	}
+	if texts[0].Body != "hello" || texts[0].Sender != "@alice:localhost" || texts[0].Timestamp != 1000 {
+		t.Errorf("Unexpected first text message: %+v", texts[0])
+	}
+	if texts[1].EventID != "$4" || texts[1].Body != "bye" {
+		t.Errorf("Unexpected second text message: %+v", texts[1])
+	}
+
+	images := response.ImageMessages()
+	if len(images) != 1 {
+		t.Fatalf("Expected 1 image message, got %d", len(images))
+	}
+	if images[0].URL != "mxc://localhost/cat" || images[0].Body != "cat.png" || images[0].Sender != "@bob:localhost" {
+		t.Errorf("Unexpected image message: %+v", images[0])
+	}
+}
+
+func TestGetMessagesUntil(t *testing.T) {
+	pages := map[string]map[string]interface{}{
+		"": {
+			"chunk": []map[string]string{{"event_id": "$5"}, {"event_id": "$4"}},
+			"end":   "t3",
+		},
+		"t3": {
+			"chunk": []map[string]string{{"event_id": "$3"}, {"event_id": "$2"}},
+			"end":   "t1",
+		},
+		"t1": {
+			"chunk": []map[string]string{{"event_id": "$1"}},
+			"end":   "t0",
+		},
+	}
+
+	var froms []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		from := req.URL.Query().Get("from")
+		froms = append(froms, from)
+		return newMockResponse(200, pages[from]), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	events, err := client.Message.GetMessagesUntil(context.Background(), "!room:localhost", "$2", 2)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.EventID)
+	}
+	if strings.Join(ids, ",") != "$3,$4,$5" {
+		t.Errorf("Expected events [$3 $4 $5], got %v", ids)
+	}
+	if len(froms) != 2 {
+		t.Errorf("Expected paging to stop after 2 pages, got %d requests", len(froms))
+	}
+}
+
+func TestMessageEventPermalink(t *testing.T) {
+	event := &MessageEvent{EventID: "$abc/def", RoomID: "!room:example.org"}
+
+	link := event.Permalink([]string{"example.org"})
+	if link != "https://matrix.to/#/%21room:example.org/$abc%2Fdef?via=example.org" {
+		t.Errorf("Unexpected event permalink '%s'", link)
+	}
+}
+
+func TestReportEvent(t *testing.T) {
+	var path string
+	var body map[string]interface{}
+	requests := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		path = req.URL.Path
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	if err := client.Message.ReportEvent(context.Background(), "!room:localhost", "$spam", -100, "spam"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/rooms/!room:localhost/report/$spam" {
+		t.Errorf("Expected report path, got '%s'", path)
+	}
+	if body["score"] != float64(-100) || body["reason"] != "spam" {
+		t.Errorf("Expected score -100 and reason 'spam', got %v", body)
+	}
+
+	for _, score := range []int{-101, 1} {
+		if err := client.Message.ReportEvent(context.Background(), "!room:localhost", "$spam", score, ""); err == nil {
+			t.Errorf("Expected error for score %d", score)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected invalid reports not to be sent, got %d requests", requests)
+	}
+}
+
+func TestWaitForEvent(t *testing.T) {
+	polls := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		polls++
+		chunk := []map[string]interface{}{
+			{"event_id": "$old", "type": "m.room.message", "content": map[string]string{"body": "hello"}},
+		}
+		if polls >= 2 {
+			chunk = append([]map[string]interface{}{
+				{"event_id": "$done", "type": "m.room.message", "content": map[string]string{"body": "deploy finished"}},
+			}, chunk...)
+		}
+		return newMockResponse(200, map[string]interface{}{"chunk": chunk}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	event, err := client.Message.WaitForEvent(ctx, "!room:localhost", func(e MessageEvent) bool {
+		return e.Content["body"] == "deploy finished"
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if event.EventID != "$done" {
+		t.Errorf("Expected event '$done', got '%s'", event.EventID)
+	}
+	if polls != 2 {
+		t.Errorf("Expected the event to be found on the second poll, got %d polls", polls)
+	}
+}
+
+func TestWaitForEventIgnoresExistingEvents(t *testing.T) {
+	polls := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		polls++
+		chunk := []map[string]interface{}{
+			{"event_id": "$old", "type": "m.room.message", "content": map[string]string{"body": "deploy finished"}},
+		}
+		if polls >= 3 {
+			chunk = append([]map[string]interface{}{
+				{"event_id": "$new", "type": "m.room.message", "content": map[string]string{"body": "deploy finished"}},
+			}, chunk...)
+		}
+		return newMockResponse(200, map[string]interface{}{"chunk": chunk}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	event, err := client.Message.WaitForEvent(ctx, "!room:localhost", func(e MessageEvent) bool {
+		return e.Content["body"] == "deploy finished"
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if event.EventID != "$new" {
+		t.Errorf("Expected event '$new', got '%s'", event.EventID)
+	}
+	if polls != 3 {
+		t.Errorf("Expected the event to be found on the third poll, got %d polls", polls)
+	}
+}
+
+func TestWaitForEventContextCancelled(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		return newMockResponse(200, map[string]interface{}{"chunk": []interface{}{}}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.Message.WaitForEvent(ctx, "!room:localhost", func(MessageEvent) bool { return false }, 10*time.Millisecond)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	var body map[string]interface{}
+	var nextBatch string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method != "POST" || req.URL.Path != "/_matrix/client/r0/search" {
+			t.Errorf("Expected POST /_matrix/client/r0/search, got %s %s", req.Method, req.URL.Path)
+		}
+		nextBatch = req.URL.Query().Get("next_batch")
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]interface{}{
+			"search_categories": map[string]interface{}{
+				"room_events": map[string]interface{}{
+					"count":      2,
+					"highlights": []string{"refund", "refunds"},
+					"next_batch": "page2",
+					"results": []interface{}{
+						map[string]interface{}{
+							"rank": 0.9,
+							"result": map[string]interface{}{
+								"event_id": "$1",
+								"room_id":  "!support:localhost",
+								"sender":   "@alice:localhost",
+								"type":     "m.room.message",
+								"content":  map[string]string{"msgtype": "m.text", "body": "How do refunds work?"},
+							},
+						},
+						map[string]interface{}{
+							"rank": 0.5,
+							"result": map[string]interface{}{
+								"event_id": "$2",
+								"room_id":  "!support:localhost",
+								"sender":   "@bob:localhost",
+								"type":     "m.room.message",
+								"content":  map[string]string{"msgtype": "m.text", "body": "Refund issued"},
+							},
+						},
+					},
+				},
+			},
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+
+	resp, err := client.Message.Search(context.Background(), &SearchRequest{
+		SearchTerm: "refund",
+		Keys:       []string{"content.body"},
+		Filter:     &RoomEventFilter{Rooms: []string{"!support:localhost"}},
+		OrderBy:    SearchOrderRank,
+		NextBatch:  "page1",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	categories, _ := body["search_categories"].(map[string]interface{})
+	criteria, _ := categories["room_events"].(map[string]interface{})
+	if criteria["search_term"] != "refund" || criteria["order_by"] != "rank" {
+		t.Errorf("Expected search_term 'refund' ordered by rank, got %v", criteria)
+	}
+	filter, _ := criteria["filter"].(map[string]interface{})
+	if rooms, _ := filter["rooms"].([]interface{}); len(rooms) != 1 || rooms[0] != "!support:localhost" {
+		t.Errorf("Expected filter on !support:localhost, got %v", criteria["filter"])
+	}
+	if nextBatch != "page1" {
+		t.Errorf("Expected next_batch 'page1', got '%s'", nextBatch)
+	}
+
+	if resp.Count != 2 || len(resp.Results) != 2 {
+		t.Fatalf("Expected 2 results, got count %d and %d results", resp.Count, len(resp.Results))
+	}
+	if resp.Results[0].Rank != 0.9 || resp.Results[1].Rank != 0.5 {
+		t.Errorf("Expected ranks 0.9 and 0.5, got %v and %v", resp.Results[0].Rank, resp.Results[1].Rank)
+	}
+	if resp.Results[0].Result.EventID != "$1" || resp.Results[1].Result.Sender != "@bob:localhost" {
+		t.Errorf("Expected results $1 and $2, got %+v", resp.Results)
+	}
+	if len(resp.Highlights) != 2 || resp.Highlights[0] != "refund" {
+		t.Errorf("Expected highlights [refund refunds], got %v", resp.Highlights)
+	}
+	if resp.NextBatch != "page2" {
+		t.Errorf("Expected next batch 'page2', got '%s'", resp.NextBatch)
+	}
+}
+
+// newConfirmClient returns a client whose sync echoes the sent event once
+// echo is set, and a syncer running against it until the test ends
+func newConfirmClient(t *testing.T, echo bool) (*Client, *Syncer) {
+	t.Helper()
+
+	var sent int32
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/sync") {
+			time.Sleep(time.Millisecond)
+			rooms := map[string]interface{}{}
+			if echo && atomic.LoadInt32(&sent) == 1 {
+				rooms["!room:localhost"] = map[string]interface{}{
+					"timeline": map[string]interface{}{"events": []interface{}{
+						map[string]interface{}{"event_id": "$other", "type": "m.room.message"},
+						map[string]interface{}{
+							"event_id": "$sent",
+							"type":     "m.room.message",
+							"sender":   "@bot:localhost",
+							"content":  map[string]string{"msgtype": "m.text", "body": "hello"},
+						},
+					}},
+				}
+			}
+			return newMockResponse(200, map[string]interface{}{
+				"next_batch": "s",
+				"rooms":      map[string]interface{}{"join": rooms},
+			}), nil
+		}
+		atomic.StoreInt32(&sent, 1)
+		return newMockResponse(200, map[string]string{"event_id": "$sent"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+	client.Sync = &SyncAPI{client: client}
+
+	syncer := NewSyncer(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		syncer.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return client, syncer
+}
+
+func TestSendAndConfirm(t *testing.T) {
+	client, syncer := newConfirmClient(t, true)
+
+	event, err := client.Message.SendAndConfirm(context.Background(), &SendMessageRequest{
+		RoomID:  "!room:localhost",
+		Content: "hello",
+	}, syncer, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if event.EventID != "$sent" {
+		t.Errorf("Expected event '$sent', got '%s'", event.EventID)
+	}
+	if event.RoomID != "!room:localhost" {
+		t.Errorf("Expected room '!room:localhost', got '%s'", event.RoomID)
+	}
+	if event.Content["body"] != "hello" {
+		t.Errorf("Expected body 'hello', got %v", event.Content["body"])
+	}
+}
+
+func TestSendAndConfirmTimeout(t *testing.T) {
+	client, syncer := newConfirmClient(t, false)
+
+	start := time.Now()
+	_, err := client.Message.SendAndConfirm(context.Background(), &SendMessageRequest{
+		RoomID:  "!room:localhost",
+		Content: "hello",
+	}, syncer, 50*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected to give up after the timeout, took %v", time.Since(start))
+	}
+}
+
+func TestSendMessageToAlias(t *testing.T) {
+	var paths []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.Method+" "+req.URL.EscapedPath())
+		if req.Method == "GET" {
+			return newMockResponse(200, map[string]interface{}{
+				"room_id": "!resolved:localhost",
+				"servers": []string{"localhost"},
+			}), nil
+		}
+		return newMockResponse(200, map[string]string{"event_id": "$e"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client}
+	client.Room = &RoomAPI{client: client}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Message.SendMessage(context.Background(), &SendMessageRequest{
+			RoomID:  "#support:localhost",
+			Content: "hello",
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	expected := []string{
+		"GET /_matrix/client/r0/directory/room/%23support:localhost",
+		"POST /_matrix/client/r0/rooms/%21resolved:localhost/send/m.room.message",
+		"POST /_matrix/client/r0/rooms/%21resolved:localhost/send/m.room.message",
+	}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected the alias to be resolved once, got %v", paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("Expected '%s', got '%s'", expected[i], paths[i])
+		}
+	}
+}
+
+func TestSendMessageRepointedAlias(t *testing.T) {
+	target := "!old:localhost"
+	var sends []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == "GET" {
+			return newMockResponse(200, map[string]interface{}{"room_id": target}), nil
+		}
+		sends = append(sends, req.URL.EscapedPath())
+		return newMockResponse(200, map[string]string{"event_id": "$e"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Message = &MessageAPI{client: client, aliasTTL: 200 * time.Millisecond}
+	client.Room = &RoomAPI{client: client}
+
+	send := func() {
+		t.Helper()
+		req := &SendMessageRequest{RoomID: "#support:localhost", Content: "hello"}
+		if _, err := client.Message.SendMessage(context.Background(), req); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if req.RoomID != "#support:localhost" {
+			t.Errorf("Expected the caller's RoomID to be kept, got '%s'", req.RoomID)
+		}
+	}
+
+	send()
+	target = "!new:localhost"
+
+	// Cached until the TTL expires
+	send()
+	time.Sleep(250 * time.Millisecond)
+	send()
+
+	// ForgetAlias drops the entry right away
+	target = "!third:localhost"
+	client.Message.ForgetAlias("#support:localhost")
+	send()
+
+	expected := []string{"%21old:localhost", "%21old:localhost", "%21new:localhost", "%21third:localhost"}
+	if len(sends) != len(expected) {
+		t.Fatalf("Expected %d sends, got %v", len(expected), sends)
+	}
+	for i, room := range expected {
+		if !strings.Contains(sends[i], "/rooms/"+room+"/") {
+			t.Errorf("Expected send %d to go to %s, got '%s'", i, room, sends[i])
+		}
+	}
+}
@@ -2,9 +2,23 @@ package taibai
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Room presets accepted by CreateRoom
+const (
+	PresetPrivateChat        = "private_chat"
+	PresetPublicChat         = "public_chat"
+	PresetTrustedPrivateChat = "trusted_private_chat"
+)
+
 // RoomAPI handles room-related operations
 type RoomAPI struct {
 	client *Client
@@ -39,10 +53,16 @@ type CreateRoomRequest struct {
 	// CreationContent contains additional creation content
 	CreationContent map[string]interface{} `json:"creation_content,omitempty"`
 
+	// RoomType is the type of the room, e.g. RoomTypeSpace (optional). It
+	// is sent as creation_content.type.
+	RoomType string `json:"-"`
+
 	// InitialState contains initial state events
 	InitialState []StateEvent `json:"initial_state,omitempty"`
 
-	// PowerLevelContentOverride overrides the default power levels
+	// PowerLevelContentOverride overrides the default power levels. Every
+	// threshold is sent, so build it with NewPowerLevels to keep the
+	// defaults for the fields you do not set.
 	PowerLevelContentOverride *PowerLevels `json:"power_level_content_override,omitempty"`
 
 	// JoinRule is the join rule of the room ("public", "knock", "invite", "private")
@@ -85,34 +105,216 @@ type StateEvent struct {
 	VerifiesIdentity bool `json:"verifies_identity,omitempty"`
 }
 
-// PowerLevels represents the power levels in a room
+// PowerLevels represents the power levels in a room. The thresholds are
+// always encoded, so a zero value is sent as 0 rather than left to the
+// server's default of 50; decoding applies the spec defaults to absent fields.
+// Start from NewPowerLevels or GetRoomPowerLevels rather than a zero value.
 type PowerLevels struct {
 	// Users overrides the power levels for specific users
 	Users map[string]int `json:"users,omitempty"`
 
 	// UsersDefault is the default power level for users
-	UsersDefault int `json:"users_default,omitempty"`
+	UsersDefault int `json:"users_default"`
 
 	// Events overrides the power levels for specific events
 	Events map[string]int `json:"events,omitempty"`
 
 	// EventsDefault is the default power level for events
-	EventsDefault int `json:"events_default,omitempty"`
+	EventsDefault int `json:"events_default"`
 
 	// StateDefault is the default power level for state events
-	StateDefault int `json:"state_default,omitempty"`
+	StateDefault int `json:"state_default"`
 
 	// Ban is the power level required to ban users
-	Ban int `json:"ban,omitempty"`
+	Ban int `json:"ban"`
 
 	// Kick is the power level required to kick users
-	Kick int `json:"kick,omitempty"`
+	Kick int `json:"kick"`
 
 	// Redact is the power level required to redact events
-	Redact int `json:"redact,omitempty"`
+	Redact int `json:"redact"`
 
 	// Invite is the power level required to invite users
-	Invite int `json:"invite,omitempty"`
+	Invite int `json:"invite"`
+}
+
+// NewPowerLevels returns power levels holding the spec defaults: 50 for
+// state_default, ban, kick and redact, 0 for the rest
+func NewPowerLevels() *PowerLevels {
+	return &PowerLevels{StateDefault: 50, Ban: 50, Kick: 50, Redact: 50}
+}
+
+// UnmarshalJSON decodes power levels, applying the spec defaults to absent
+// fields: 50 for state_default, ban, kick and redact, 0 for the rest
+func (p *PowerLevels) UnmarshalJSON(data []byte) error {
+	type plain PowerLevels
+	levels := plain(*NewPowerLevels())
+	if err := json.Unmarshal(data, &levels); err != nil {
+		return err
+	}
+	*p = PowerLevels(levels)
+	return nil
+}
+
+// UserLevel returns the power level of userID
+func (p *PowerLevels) UserLevel(userID string) int {
+	if level, ok := p.Users[userID]; ok {
+		return level
+	}
+	return p.UsersDefault
+}
+
+// CanSendEvent reports whether userID has enough power to send an event of
+// eventType; state selects the state_default over events_default
+func (p *PowerLevels) CanSendEvent(userID, eventType string, state bool) bool {
+	required := p.EventsDefault
+	if state {
+		required = p.StateDefault
+	}
+	if level, ok := p.Events[eventType]; ok {
+		required = level
+	}
+	return p.UserLevel(userID) >= required
+}
+
+// MaxPowerLevel is the highest power level the server accepts, the largest
+// integer representable in canonical JSON
+const MaxPowerLevel int64 = 1<<53 - 1
+
+// Validate checks that every level lies within 0..MaxPowerLevel and that at
+// least one user keeps enough power to change m.room.power_levels again, so
+// the room cannot be frozen by accident. The levels are checked as they will
+// be sent: a zero threshold is sent as 0, not as the server's default.
+func (p *PowerLevels) Validate() error {
+	check := func(name string, level int) error {
+		if level < 0 || int64(level) > MaxPowerLevel {
+			return fmt.Errorf("invalid power level %d for %s: must be between 0 and %d", level, name, MaxPowerLevel)
+		}
+		return nil
+	}
+
+	fields := []struct {
+		name  string
+		level int
+	}{
+		{"users_default", p.UsersDefault},
+		{"events_default", p.EventsDefault},
+		{"state_default", p.StateDefault},
+		{"ban", p.Ban},
+		{"kick", p.Kick},
+		{"redact", p.Redact},
+		{"invite", p.Invite},
+	}
+	for _, field := range fields {
+		if err := check(field.name, field.level); err != nil {
+			return err
+		}
+	}
+
+	highest := p.UsersDefault
+	for userID, level := range p.Users {
+		if err := check("user "+userID, level); err != nil {
+			return err
+		}
+		if level > highest {
+			highest = level
+		}
+	}
+	for eventType, level := range p.Events {
+		if err := check("event "+eventType, level); err != nil {
+			return err
+		}
+	}
+
+	required := p.StateDefault
+	if level, ok := p.Events["m.room.power_levels"]; ok {
+		required = level
+	}
+	if highest < required {
+		return fmt.Errorf("invalid power levels: no user reaches level %d required to change m.room.power_levels", required)
+	}
+	return nil
+}
+
+// LevelChange represents a power level that changed between two PowerLevels
+type LevelChange struct {
+	// Old is the level before the change
+	Old int
+
+	// New is the level after the change
+	New int
+}
+
+// PowerLevelDiff describes the differences between two PowerLevels
+type PowerLevelDiff struct {
+	// AddedUsers maps users that gained an explicit level to that level
+	AddedUsers map[string]int
+
+	// RemovedUsers maps users that lost their explicit level to the old level
+	RemovedUsers map[string]int
+
+	// ChangedUsers maps users whose explicit level changed to the change
+	ChangedUsers map[string]LevelChange
+
+	// ChangedThresholds maps changed thresholds to the change, keyed by their
+	// JSON name ("ban", "kick", "redact", "invite", "users_default",
+	// "events_default", "state_default")
+	ChangedThresholds map[string]LevelChange
+}
+
+// Empty reports whether the diff contains no changes
+func (d PowerLevelDiff) Empty() bool {
+	return len(d.AddedUsers) == 0 && len(d.RemovedUsers) == 0 &&
+		len(d.ChangedUsers) == 0 && len(d.ChangedThresholds) == 0
+}
+
+// Diff reports how other differs from p, treating p as the state before the
+// change and other as the state after it
+func (p *PowerLevels) Diff(other *PowerLevels) PowerLevelDiff {
+	if other == nil {
+		other = &PowerLevels{}
+	}
+
+	diff := PowerLevelDiff{
+		AddedUsers:        make(map[string]int),
+		RemovedUsers:      make(map[string]int),
+		ChangedUsers:      make(map[string]LevelChange),
+		ChangedThresholds: make(map[string]LevelChange),
+	}
+
+	for userID, oldLevel := range p.Users {
+		newLevel, ok := other.Users[userID]
+		if !ok {
+			diff.RemovedUsers[userID] = oldLevel
+		} else if newLevel != oldLevel {
+			diff.ChangedUsers[userID] = LevelChange{Old: oldLevel, New: newLevel}
+		}
+	}
+	for userID, newLevel := range other.Users {
+		if _, ok := p.Users[userID]; !ok {
+			diff.AddedUsers[userID] = newLevel
+		}
+	}
+
+	thresholds := []struct {
+		name     string
+		old, new int
+	}{
+		{"ban", p.Ban, other.Ban},
+		{"kick", p.Kick, other.Kick},
+		{"redact", p.Redact, other.Redact},
+		{"invite", p.Invite, other.Invite},
+		{"users_default", p.UsersDefault, other.UsersDefault},
+		{"events_default", p.EventsDefault, other.EventsDefault},
+		{"state_default", p.StateDefault, other.StateDefault},
+	}
+	for _, threshold := range thresholds {
+		if threshold.old != threshold.new {
+			diff.ChangedThresholds[threshold.name] = LevelChange{Old: threshold.old, New: threshold.new}
+		}
+	}
+
+	return diff
 }
 
 // CreateRoomResponse represents the response from creating a room
@@ -170,17 +372,51 @@ func (r *RoomAPI) JoinRoom(ctx context.Context, roomIDOrAlias string, req *JoinR
 	}
 
 	result := &JoinRoomResponse{}
-	err := r.client.POST(ctx, "/_matrix/client/r0/join/"+roomIDOrAlias, req, result)
+	// Aliases start with '#', which must not be read as a URL fragment
+	err := r.client.POST(ctx, r.client.apiPrefix()+"/join/"+pathEscape(roomIDOrAlias), req, result)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-// JoinRoomResponse represents the response from joining a room
+// JoinRoomResponse represents the response from joining a room. Servers
+// may return fields beyond these; unknown fields are ignored when decoding.
 type JoinRoomResponse struct {
 	// RoomID is the room that was joined
 	RoomID string `json:"room_id"`
+
+	// EventID is the ID of the join membership event, if returned
+	EventID string `json:"event_id,omitempty"`
+
+	// Servers lists the servers used to perform the join, if returned
+	Servers []string `json:"servers,omitempty"`
+}
+
+// KnockRoom asks to join a knock-restricted room. serverNames are servers
+// to try the knock through, needed for rooms the server does not know yet.
+func (r *RoomAPI) KnockRoom(ctx context.Context, roomIDOrAlias string, reason string, serverNames []string) (*JoinRoomResponse, error) {
+	body := map[string]string{}
+	if reason != "" {
+		body["reason"] = reason
+	}
+
+	var query url.Values
+	if len(serverNames) > 0 {
+		query = url.Values{"server_name": serverNames}
+	}
+
+	result := &JoinRoomResponse{}
+	err := r.client.doJSON(ctx, &Request{
+		Method:      http.MethodPost,
+		Path:        r.client.apiPrefix() + "/knock/" + pathEscape(roomIDOrAlias),
+		Body:        body,
+		QueryValues: query,
+	}, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // LeaveRoomRequest represents a request to leave a room
@@ -195,7 +431,7 @@ func (r *RoomAPI) LeaveRoom(ctx context.Context, roomID string, req *LeaveRoomRe
 		req = &LeaveRoomRequest{}
 	}
 
-	return r.client.POST(ctx, "/_matrix/client/r0/rooms/"+roomID+"/leave", req, nil)
+	return r.client.POST(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/leave", req, nil)
 }
 
 // InviteUserRequest represents a request to invite a user to a room
@@ -209,7 +445,7 @@ type InviteUserRequest struct {
 
 // InviteUser invites a user to a room
 func (r *RoomAPI) InviteUser(ctx context.Context, roomID string, req *InviteUserRequest) error {
-	return r.client.POST(ctx, "/_matrix/client/r0/rooms/"+roomID+"/invite", req, nil)
+	return r.client.POST(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/invite", req, nil)
 }
 
 // KickUserRequest represents a request to kick a user from a room
@@ -223,7 +459,7 @@ type KickUserRequest struct {
 
 // KickUser kicks a user from a room
 func (r *RoomAPI) KickUser(ctx context.Context, roomID string, req *KickUserRequest) error {
-	return r.client.POST(ctx, "/_matrix/client/r0/rooms/"+roomID+"/kick", req, nil)
+	return r.client.POST(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/kick", req, nil)
 }
 
 // BanUserRequest represents a request to ban a user from a room
@@ -237,7 +473,7 @@ type BanUserRequest struct {
 
 // BanUser bans a user from a room
 func (r *RoomAPI) BanUser(ctx context.Context, roomID string, req *BanUserRequest) error {
-	return r.client.POST(ctx, "/_matrix/client/r0/rooms/"+roomID+"/ban", req, nil)
+	return r.client.POST(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/ban", req, nil)
 }
 
 // UnbanUserRequest represents a request to unban a user from a room
@@ -251,14 +487,14 @@ type UnbanUserRequest struct {
 
 // UnbanUser unbans a user from a room
 func (r *RoomAPI) UnbanUser(ctx context.Context, roomID string, req *UnbanUserRequest) error {
-	return r.client.POST(ctx, "/_matrix/client/r0/rooms/"+roomID+"/unban", req, nil)
+	return r.client.POST(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/unban", req, nil)
 }
 
 // GetRoomState gets the state of a room
 func (r *RoomAPI) GetRoomState(ctx context.Context, roomID, eventType, stateKey string) (interface{}, error) {
-	path := "/_matrix/client/r0/rooms/" + roomID + "/state/" + eventType
+	path := r.client.apiPrefix() + "/rooms/" + pathEscape(roomID) + "/state/" + pathEscape(eventType)
 	if stateKey != "" {
-		path += "/" + stateKey
+		path += "/" + pathEscape(stateKey)
 	}
 
 	var result interface{}
@@ -269,15 +505,124 @@ func (r *RoomAPI) GetRoomState(ctx context.Context, roomID, eventType, stateKey
 	return result, nil
 }
 
+// GetAllRoomState gets every current state event of a room
+func (r *RoomAPI) GetAllRoomState(ctx context.Context, roomID string) ([]StateEvent, error) {
+	var result []StateEvent
+	err := r.client.GET(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/state", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ExportState returns a snapshot of the full current state of a room, for
+// backup or to replay with ImportState
+func (r *RoomAPI) ExportState(ctx context.Context, roomID string) ([]StateEvent, error) {
+	return r.GetAllRoomState(ctx, roomID)
+}
+
+// importSkippedStateTypes lists state that ImportState never replays:
+// m.room.create is fixed at creation, m.room.member can only change through
+// join/invite/kick/ban, and m.room.tombstone would shut the target room down.
+var importSkippedStateTypes = map[string]bool{
+	"m.room.create":    true,
+	"m.room.member":    true,
+	"m.room.tombstone": true,
+}
+
+// ImportState replays exported state events into roomID. Events of the
+// types in importSkippedStateTypes (m.room.create, m.room.member and
+// m.room.tombstone) are skipped. m.room.power_levels is written last so
+// earlier writes are not rejected by a more restrictive imported level.
+// It stops at the first failed write.
+func (r *RoomAPI) ImportState(ctx context.Context, roomID string, events []StateEvent) error {
+	var powerLevels []StateEvent
+	for _, event := range events {
+		if importSkippedStateTypes[event.Type] {
+			continue
+		}
+		if event.Type == "m.room.power_levels" {
+			powerLevels = append(powerLevels, event)
+			continue
+		}
+		if err := r.importStateEvent(ctx, roomID, event); err != nil {
+			return err
+		}
+	}
+	for _, event := range powerLevels {
+		if err := r.importStateEvent(ctx, roomID, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importStateEvent writes a single exported state event
+func (r *RoomAPI) importStateEvent(ctx context.Context, roomID string, event StateEvent) error {
+	if _, err := r.SetRoomState(ctx, roomID, event.Type, event.StateKey, event.Content); err != nil {
+		return fmt.Errorf("failed to import %s state %q: %w", event.Type, event.StateKey, err)
+	}
+	return nil
+}
+
+// RoomPermalink returns a matrix.to link to the room. via lists servers
+// that can route to the room (recommended for room IDs).
+func (r *RoomAPI) RoomPermalink(roomID string, via []string) string {
+	return permalink(roomID, "", via)
+}
+
+// permalink builds a matrix.to URL for a room and, optionally, an event
+func permalink(roomIDOrAlias, eventID string, via []string) string {
+	link := "https://matrix.to/#/" + pathEscape(roomIDOrAlias)
+	if eventID != "" {
+		link += "/" + pathEscape(eventID)
+	}
+	if len(via) > 0 {
+		link += "?" + url.Values{"via": via}.Encode()
+	}
+	return link
+}
+
 // GetRoomMembers gets the members of a room
 func (r *RoomAPI) GetRoomMembers(ctx context.Context, roomID string, at string) (*RoomMembersResponse, error) {
+	return r.GetRoomMembersFiltered(ctx, roomID, &RoomMembersOptions{At: at})
+}
+
+// RoomMembersOptions narrows the member list returned by GetRoomMembersFiltered
+type RoomMembersOptions struct {
+	// At is a sync or pagination token; members are returned as of that
+	// point in the timeline (optional)
+	At string
+
+	// Membership only includes members with this membership (optional)
+	Membership Membership
+
+	// NotMembership excludes members with this membership (optional)
+	NotMembership Membership
+}
+
+// GetRoomMembersFiltered gets the members of a room like GetRoomMembers,
+// restricted by opts (optional). At and the membership filters combine: the
+// filters apply to the membership each user had at the At token, so
+// {At: prevBatch, Membership: MembershipJoin} lists the users joined at that
+// point. For large rooms this keeps the payload to the members actually
+// needed, e.g. those a lazy-loading sync left out.
+func (r *RoomAPI) GetRoomMembersFiltered(ctx context.Context, roomID string, opts *RoomMembersOptions) (*RoomMembersResponse, error) {
 	query := map[string]string{}
-	if at != "" {
-		query["at"] = at
+	if opts != nil {
+		if opts.At != "" {
+			query["at"] = opts.At
+		}
+		if opts.Membership != "" {
+			query["membership"] = string(opts.Membership)
+		}
+		if opts.NotMembership != "" {
+			query["not_membership"] = string(opts.NotMembership)
+		}
 	}
 
 	result := &RoomMembersResponse{}
-	err := r.client.GET(ctx, "/_matrix/client/r0/rooms/"+roomID+"/members", query, result)
+	err := r.client.GET(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/members", query, result)
 	if err != nil {
 		return nil, err
 	}
@@ -314,10 +659,32 @@ type MemberEvent struct {
 	Timestamp int64 `json:"timestamp,omitempty"`
 }
 
+// Membership is the membership state of a user in a room
+type Membership string
+
+// Membership states
+const (
+	MembershipJoin   Membership = "join"
+	MembershipLeave  Membership = "leave"
+	MembershipInvite Membership = "invite"
+	MembershipBan    Membership = "ban"
+	MembershipKnock  Membership = "knock"
+)
+
+// Valid reports whether m is one of the known membership states. Unknown
+// values still decode, so events from newer servers are not rejected.
+func (m Membership) Valid() bool {
+	switch m {
+	case MembershipJoin, MembershipLeave, MembershipInvite, MembershipBan, MembershipKnock:
+		return true
+	}
+	return false
+}
+
 // MemberContent represents the content of a member event
 type MemberContent struct {
-	// Membership is the membership state ("join", "leave", "ban", "invite")
-	Membership string `json:"membership"`
+	// Membership is the membership state
+	Membership Membership `json:"membership"`
 
 	// DisplayName is the display name of the user
 	DisplayName string `json:"displayname,omitempty"`
@@ -332,31 +699,48 @@ type MemberContent struct {
 // GetRoom gets the information of a room
 func (r *RoomAPI) GetRoom(ctx context.Context, roomID string) (*Room, error) {
 	result := &Room{}
-	err := r.client.GET(ctx, "/_matrix/client/r0/rooms/"+roomID, nil, result)
+	err := r.client.GET(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID), nil, result)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-// CreateRoom creates a new room
+// CreateRoom creates a new room. Defaults are applied to a copy, so req and
+// its CreationContent map are left unchanged.
 func (r *RoomAPI) CreateRoom(ctx context.Context, req *CreateRoomRequest) (*CreateRoomResponse, error) {
-	if req == nil {
-		req = &CreateRoomRequest{}
+	var body CreateRoomRequest
+	if req != nil {
+		body = *req
 	}
 
 	// Set default visibility
-	if req.Visibility == "" {
-		req.Visibility = "private"
+	if body.Visibility == "" {
+		body.Visibility = "private"
 	}
 
-	// Set default preset
-	if req.Preset == "" {
-		req.Preset = "private_chat"
+	// Set default preset and reject unknown ones before the server does
+	body.Preset = strings.ToLower(strings.TrimSpace(body.Preset))
+	switch body.Preset {
+	case "":
+		body.Preset = PresetPrivateChat
+	case PresetPrivateChat, PresetPublicChat, PresetTrustedPrivateChat:
+	default:
+		return nil, fmt.Errorf("invalid room preset %q: must be one of %s, %s, %s",
+			body.Preset, PresetPrivateChat, PresetPublicChat, PresetTrustedPrivateChat)
+	}
+
+	if body.RoomType != "" {
+		content := make(map[string]interface{}, len(body.CreationContent)+1)
+		for key, value := range body.CreationContent {
+			content[key] = value
+		}
+		content["type"] = body.RoomType
+		body.CreationContent = content
 	}
 
 	result := &CreateRoomResponse{}
-	err := r.client.POST(ctx, "/_matrix/client/r0/createRoom", req, result)
+	err := r.client.POST(ctx, r.client.apiPrefix()+"/createRoom", &body, result)
 	if err != nil {
 		return nil, err
 	}
@@ -374,6 +758,15 @@ func (r *RoomAPI) CreatePublicRoom(ctx context.Context, name, topic, alias strin
 	})
 }
 
+// CreateSpace creates a new private space
+func (r *RoomAPI) CreateSpace(ctx context.Context, name, topic string) (*CreateRoomResponse, error) {
+	return r.CreateRoom(ctx, &CreateRoomRequest{
+		Name:     name,
+		Topic:    topic,
+		RoomType: RoomTypeSpace,
+	})
+}
+
 // CreatePrivateRoom creates a new private room
 func (r *RoomAPI) CreatePrivateRoom(ctx context.Context, name string, invite []string) (*CreateRoomResponse, error) {
 	return r.CreateRoom(ctx, &CreateRoomRequest{
@@ -384,34 +777,81 @@ func (r *RoomAPI) CreatePrivateRoom(ctx context.Context, name string, invite []s
 	})
 }
 
+// SetRoomState sends an arbitrary state event and returns its event ID.
+// stateKey may be empty for events such as m.room.pinned_events.
+func (r *RoomAPI) SetRoomState(ctx context.Context, roomID, eventType, stateKey string, content interface{}) (*SendMessageResponse, error) {
+	path := r.client.apiPrefix() + "/rooms/" + pathEscape(roomID) + "/state/" + pathEscape(eventType)
+	if stateKey != "" {
+		path += "/" + pathEscape(stateKey)
+	}
+
+	result := &SendMessageResponse{}
+	err := r.client.PUT(ctx, path, content, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // SetRoomName sets the name of a room
 func (r *RoomAPI) SetRoomName(ctx context.Context, roomID, name string) error {
+	_, err := r.SetRoomNameWithEventID(ctx, roomID, name)
+	return err
+}
+
+// SetRoomNameWithEventID sets the name of a room and returns the event ID of
+// the m.room.name state event, e.g. for audit logs
+func (r *RoomAPI) SetRoomNameWithEventID(ctx context.Context, roomID, name string) (string, error) {
 	body := map[string]string{
 		"name": name,
 	}
-	return r.client.PUT(ctx, "/_matrix/client/r0/rooms/"+roomID+"/state/m.room.name", body, nil)
+	return r.setStateEventID(ctx, roomID, "m.room.name", body)
 }
 
 // SetRoomTopic sets the topic of a room
 func (r *RoomAPI) SetRoomTopic(ctx context.Context, roomID, topic string) error {
+	_, err := r.SetRoomTopicWithEventID(ctx, roomID, topic)
+	return err
+}
+
+// SetRoomTopicWithEventID sets the topic of a room and returns the event ID
+// of the m.room.topic state event
+func (r *RoomAPI) SetRoomTopicWithEventID(ctx context.Context, roomID, topic string) (string, error) {
 	body := map[string]string{
 		"topic": topic,
 	}
-	return r.client.PUT(ctx, "/_matrix/client/r0/rooms/"+roomID+"/state/m.room.topic", body, nil)
+	return r.setStateEventID(ctx, roomID, "m.room.topic", body)
 }
 
 // SetRoomAvatar sets the avatar of a room
 func (r *RoomAPI) SetRoomAvatar(ctx context.Context, roomID, avatarURL string) error {
+	_, err := r.SetRoomAvatarWithEventID(ctx, roomID, avatarURL)
+	return err
+}
+
+// SetRoomAvatarWithEventID sets the avatar of a room and returns the event
+// ID of the m.room.avatar state event
+func (r *RoomAPI) SetRoomAvatarWithEventID(ctx context.Context, roomID, avatarURL string) (string, error) {
 	body := map[string]string{
 		"url": avatarURL,
 	}
-	return r.client.PUT(ctx, "/_matrix/client/r0/rooms/"+roomID+"/state/m.room.avatar", body, nil)
+	return r.setStateEventID(ctx, roomID, "m.room.avatar", body)
+}
+
+// setStateEventID sends a state event with an empty state key and returns
+// its event ID
+func (r *RoomAPI) setStateEventID(ctx context.Context, roomID, eventType string, content interface{}) (string, error) {
+	resp, err := r.SetRoomState(ctx, roomID, eventType, "", content)
+	if err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
 }
 
 // GetJoinedRooms gets the rooms that the user has joined
 func (r *RoomAPI) GetJoinedRooms(ctx context.Context) (*JoinedRoomsResponse, error) {
 	result := &JoinedRoomsResponse{}
-	err := r.client.GET(ctx, "/_matrix/client/r0/joined_rooms", nil, result)
+	err := r.client.GET(ctx, r.client.apiPrefix()+"/joined_rooms", nil, result)
 	if err != nil {
 		return nil, err
 	}
@@ -424,25 +864,131 @@ type JoinedRoomsResponse struct {
 	JoinedRooms []string `json:"joined_rooms"`
 }
 
+// RoomTypeSpace is the room type of spaces
+const RoomTypeSpace = "m.space"
+
+// JoinedRoom is a joined room classified by its room type
+type JoinedRoom struct {
+	// ID is the room ID
+	ID string
+
+	// Type is the room type from m.room.create (RoomTypeSpace for spaces,
+	// empty for regular rooms)
+	Type string
+}
+
+// GetJoinedRoomsWithType gets the joined rooms along with their room type,
+// so callers can tell spaces from regular rooms. It reads the m.room.create
+// event of every room.
+func (r *RoomAPI) GetJoinedRoomsWithType(ctx context.Context) ([]JoinedRoom, error) {
+	joined, err := r.GetJoinedRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]JoinedRoom, 0, len(joined.JoinedRooms))
+	for _, roomID := range joined.JoinedRooms {
+		roomType, err := r.getStateString(ctx, roomID, "m.room.create", "type")
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, JoinedRoom{ID: roomID, Type: roomType})
+	}
+	return rooms, nil
+}
+
+// GetUnreadCounts gets the unread notification counts of a joined room. It
+// is read from a one-off sync filtered to the room.
+func (r *RoomAPI) GetUnreadCounts(ctx context.Context, roomID string) (*UnreadNotificationCounts, error) {
+	filter, err := json.Marshal(map[string]interface{}{
+		"account_data": map[string]interface{}{"types": []string{}},
+		"presence":     map[string]interface{}{"types": []string{}},
+		"room": map[string]interface{}{
+			"rooms":        []string{roomID},
+			"account_data": map[string]interface{}{"types": []string{}},
+			"ephemeral":    map[string]interface{}{"types": []string{}},
+			"state":        map[string]interface{}{"types": []string{}},
+			"timeline":     map[string]interface{}{"limit": 0},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Sync.Sync(ctx, &SyncRequest{Filter: string(filter)})
+	if err != nil {
+		return nil, err
+	}
+
+	room, ok := resp.Rooms.Join[roomID]
+	if !ok {
+		return nil, fmt.Errorf("room %s is not joined", roomID)
+	}
+	return &room.UnreadNotifications, nil
+}
+
 // GetRoomPowerLevels gets the power levels of a room
 func (r *RoomAPI) GetRoomPowerLevels(ctx context.Context, roomID string) (*PowerLevels, error) {
 	result := &PowerLevels{}
-	err := r.client.GET(ctx, "/_matrix/client/r0/rooms/"+roomID+"/state/m.room.power_levels", nil, result)
+	err := r.client.GET(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/state/m.room.power_levels", nil, result)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-// SetRoomPowerLevels sets the power levels of a room
+// CanSend reports whether userID may send an event of eventType in the room,
+// based on a single fetch of the room's power levels
+func (r *RoomAPI) CanSend(ctx context.Context, roomID, userID, eventType string, state bool) (bool, error) {
+	levels, err := r.GetRoomPowerLevels(ctx, roomID)
+	if err != nil {
+		return false, err
+	}
+	return levels.CanSendEvent(userID, eventType, state), nil
+}
+
+// SetRoomPowerLevels sets the power levels of a room. It refuses levels that
+// fail Validate or would leave the authenticated user unable to change them
+// again; use SetRoomPowerLevelsUnchecked to apply such levels deliberately.
+// The checks see the same thresholds the server does, since every threshold
+// is sent, zeros included.
 func (r *RoomAPI) SetRoomPowerLevels(ctx context.Context, roomID string, levels *PowerLevels) error {
-	return r.client.PUT(ctx, "/_matrix/client/r0/rooms/"+roomID+"/state/m.room.power_levels", levels, nil)
+	_, err := r.SetRoomPowerLevelsWithEventID(ctx, roomID, levels)
+	return err
+}
+
+// SetRoomPowerLevelsWithEventID is SetRoomPowerLevels returning the event ID
+// of the m.room.power_levels state event
+func (r *RoomAPI) SetRoomPowerLevelsWithEventID(ctx context.Context, roomID string, levels *PowerLevels) (string, error) {
+	if err := levels.Validate(); err != nil {
+		return "", err
+	}
+
+	whoami, err := r.client.Auth.WhoAmI(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the current user: %w", err)
+	}
+	if whoami.UserID == "" {
+		return "", fmt.Errorf("failed to determine the current user: empty user_id")
+	}
+
+	if !levels.CanSendEvent(whoami.UserID, "m.room.power_levels", true) {
+		return "", fmt.Errorf("power levels would lock %s out of changing m.room.power_levels", whoami.UserID)
+	}
+
+	return r.setStateEventID(ctx, roomID, "m.room.power_levels", levels)
+}
+
+// SetRoomPowerLevelsUnchecked sets the power levels of a room without the
+// self-lockout check of SetRoomPowerLevels
+func (r *RoomAPI) SetRoomPowerLevelsUnchecked(ctx context.Context, roomID string, levels *PowerLevels) error {
+	return r.client.PUT(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/state/m.room.power_levels", levels, nil)
 }
 
 // GetRoomAliases gets the aliases of a room
 func (r *RoomAPI) GetRoomAliases(ctx context.Context, roomID string) (*RoomAliasesResponse, error) {
 	result := &RoomAliasesResponse{}
-	err := r.client.GET(ctx, "/_matrix/client/r0/rooms/"+roomID+"/aliases", nil, result)
+	err := r.client.GET(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/aliases", nil, result)
 	if err != nil {
 		return nil, err
 	}
@@ -455,10 +1001,63 @@ type RoomAliasesResponse struct {
 	Aliases []string `json:"aliases"`
 }
 
+// UpgradeRoom upgrades the room to newVersion. The server creates a
+// replacement room and tombstones the old one.
+func (r *RoomAPI) UpgradeRoom(ctx context.Context, roomID, newVersion string) (*UpgradeRoomResponse, error) {
+	body := map[string]string{
+		"new_version": newVersion,
+	}
+
+	result := &UpgradeRoomResponse{}
+	err := r.client.POST(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/upgrade", body, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UpgradeRoomResponse represents the response from upgrading a room
+type UpgradeRoomResponse struct {
+	// ReplacementRoom is the ID of the new room
+	ReplacementRoom string `json:"replacement_room"`
+}
+
+// CreateAlias maps alias (e.g. "#ops:example.org") to the room
+func (r *RoomAPI) CreateAlias(ctx context.Context, alias, roomID string) error {
+	body := map[string]string{
+		"room_id": roomID,
+	}
+	return r.client.PUT(ctx, r.client.apiPrefix()+"/directory/room/"+pathEscape(alias), body, nil)
+}
+
+// DeleteAlias removes an alias mapping
+func (r *RoomAPI) DeleteAlias(ctx context.Context, alias string) error {
+	return r.client.DELETE(ctx, r.client.apiPrefix()+"/directory/room/"+pathEscape(alias), nil, nil)
+}
+
+// ResolveAlias looks up the room an alias points to
+func (r *RoomAPI) ResolveAlias(ctx context.Context, alias string) (*ResolveAliasResponse, error) {
+	result := &ResolveAliasResponse{}
+	err := r.client.GET(ctx, r.client.apiPrefix()+"/directory/room/"+pathEscape(alias), nil, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ResolveAliasResponse represents the response from resolving an alias
+type ResolveAliasResponse struct {
+	// RoomID is the room the alias points to
+	RoomID string `json:"room_id"`
+
+	// Servers lists servers aware of the room, usable as join hints
+	Servers []string `json:"servers"`
+}
+
 // GetUserRooms gets the rooms that a user has joined (admin API)
 func (r *RoomAPI) GetUserRooms(ctx context.Context, userID string) (*JoinedRoomsResponse, error) {
 	result := &JoinedRoomsResponse{}
-	err := r.client.GET(ctx, "/_matrix/client/r0/admin/rooms/"+userID+"/joined_rooms", nil, result)
+	err := r.client.GET(ctx, "/_matrix/client/r0/admin/rooms/"+pathEscape(userID)+"/joined_rooms", nil, result)
 	if err != nil {
 		return nil, err
 	}
@@ -468,7 +1067,7 @@ func (r *RoomAPI) GetUserRooms(ctx context.Context, userID string) (*JoinedRooms
 // GetRoomDetails gets the details of a room (admin API)
 func (r *RoomAPI) GetRoomDetails(ctx context.Context, roomID string) (*RoomDetailsResponse, error) {
 	result := &RoomDetailsResponse{}
-	err := r.client.GET(ctx, "/_matrix/client/r0/admin/rooms/"+roomID, nil, result)
+	err := r.client.GET(ctx, "/_matrix/client/r0/admin/rooms/"+pathEscape(roomID), nil, result)
 	if err != nil {
 		return nil, err
 	}
@@ -511,12 +1110,127 @@ type RoomDetailsResponse struct {
 	CreateAt time.Time `json:"create_at"`
 }
 
+// UnmarshalJSON decodes room details. create_at is sent by the server as an
+// integer timestamp in seconds or milliseconds; RFC 3339 strings are also
+// accepted.
+func (r *RoomDetailsResponse) UnmarshalJSON(data []byte) error {
+	type plain RoomDetailsResponse
+	aux := struct {
+		*plain
+		CreateAt json.RawMessage `json:"create_at"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.CreateAt = time.Time{}
+	if len(aux.CreateAt) == 0 || string(aux.CreateAt) == "null" {
+		return nil
+	}
+	if aux.CreateAt[0] == '"' {
+		return json.Unmarshal(aux.CreateAt, &r.CreateAt)
+	}
+
+	var ts int64
+	if err := json.Unmarshal(aux.CreateAt, &ts); err != nil {
+		return fmt.Errorf("invalid create_at: %w", err)
+	}
+	switch {
+	case ts <= 0:
+	case ts >= 1e11:
+		// 1e11 seconds is in the year 5138; as milliseconds it is 1973
+		r.CreateAt = time.UnixMilli(ts)
+	default:
+		r.CreateAt = time.Unix(ts, 0)
+	}
+	return nil
+}
+
 // DeleteRoom deletes a room (admin API)
 func (r *RoomAPI) DeleteRoom(ctx context.Context, roomID string, purge bool) error {
-	return r.client.DELETE(ctx, "/_matrix/client/r0/admin/rooms/"+roomID, map[string]string{"purge": "true"}, nil)
+	query := map[string]string{
+		"purge": strconv.FormatBool(purge),
+	}
+	return r.client.DELETE(ctx, "/_matrix/client/r0/admin/rooms/"+pathEscape(roomID), query, nil)
 }
 
 // ForgetRoom forgets a room
 func (r *RoomAPI) ForgetRoom(ctx context.Context, roomID string) error {
-	return r.client.POST(ctx, "/_matrix/client/r0/rooms/"+roomID+"/forget", nil, nil)
+	return r.client.POST(ctx, r.client.apiPrefix()+"/rooms/"+pathEscape(roomID)+"/forget", nil, nil)
+}
+
+// ComputeDisplayName computes the display name of a room following the
+// Matrix fallback order: m.room.name, then m.room.canonical_alias, then the
+// names of the other members, and finally "Empty room"
+func (r *RoomAPI) ComputeDisplayName(ctx context.Context, roomID, selfUserID string) (string, error) {
+	name, err := r.getStateString(ctx, roomID, "m.room.name", "name")
+	if err != nil {
+		return "", err
+	}
+	if name != "" {
+		return name, nil
+	}
+
+	alias, err := r.getStateString(ctx, roomID, "m.room.canonical_alias", "alias")
+	if err != nil {
+		return "", err
+	}
+	if alias != "" {
+		return alias, nil
+	}
+
+	members, err := r.GetRoomMembers(ctx, roomID, "")
+	if err != nil {
+		return "", err
+	}
+
+	others := make([]MemberEvent, 0, len(members.Chunk))
+	for _, member := range members.Chunk {
+		if member.StateKey == selfUserID {
+			continue
+		}
+		if member.Content.Membership != MembershipJoin && member.Content.Membership != MembershipInvite {
+			continue
+		}
+		others = append(others, member)
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].StateKey < others[j].StateKey
+	})
+
+	names := make([]string, 0, len(others))
+	for _, member := range others {
+		if member.Content.DisplayName != "" {
+			names = append(names, member.Content.DisplayName)
+		} else {
+			names = append(names, member.StateKey)
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return "Empty room", nil
+	case 1:
+		return names[0], nil
+	case 2:
+		return names[0] + " and " + names[1], nil
+	default:
+		return fmt.Sprintf("%s, %s and %d others", names[0], names[1], len(names)-2), nil
+	}
+}
+
+// getStateString reads a string field of a state event, returning "" when
+// the event is not set
+func (r *RoomAPI) getStateString(ctx context.Context, roomID, eventType, field string) (string, error) {
+	state, err := r.GetRoomState(ctx, roomID, eventType, "")
+	if err != nil {
+		if IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	content, _ := state.(map[string]interface{})
+	value, _ := content[field].(string)
+	return value, nil
 }
@@ -1,12 +1,15 @@
 package taibai
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreateRoom(t *testing.T) {
@@ -20,8 +23,8 @@ func TestCreateRoom(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -53,8 +56,8 @@ func TestCreateRoomWithAlias(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -89,8 +92,8 @@ func TestCreatePublicRoom(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -116,8 +119,8 @@ func TestCreatePrivateRoom(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -143,8 +146,8 @@ func TestJoinRoom(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -170,8 +173,8 @@ func TestJoinRoomWithServerName(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -197,8 +200,8 @@ func TestLeaveRoom(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -218,8 +221,8 @@ func TestLeaveRoomWithReason(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -241,8 +244,8 @@ func TestInviteUser(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -264,8 +267,8 @@ func TestInviteUserWithReason(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -288,8 +291,8 @@ func TestKickUser(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -311,8 +314,8 @@ func TestBanUser(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -334,8 +337,8 @@ func TestUnbanUser(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -363,8 +366,8 @@ func TestGetRoom(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -398,8 +401,8 @@ func TestGetRoomState(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -442,8 +445,8 @@ func TestGetRoomMembers(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -458,6 +461,47 @@ func TestGetRoomMembers(t *testing.T) {
 	}
 }
 
+func TestGetRoomMembersFiltered(t *testing.T) {
+	var query url.Values
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		query = req.URL.Query()
+		return newMockResponse(200, map[string]interface{}{"chunk": []interface{}{}}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	_, err := client.Room.GetRoomMembersFiltered(context.Background(), "!test-room:localhost", &RoomMembersOptions{
+		At:            "s72595_4483",
+		Membership:    MembershipJoin,
+		NotMembership: MembershipLeave,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if query.Get("at") != "s72595_4483" {
+		t.Errorf("Expected at 's72595_4483', got '%s'", query.Get("at"))
+	}
+	if query.Get("membership") != "join" {
+		t.Errorf("Expected membership 'join', got '%s'", query.Get("membership"))
+	}
+	if query.Get("not_membership") != "leave" {
+		t.Errorf("Expected not_membership 'leave', got '%s'", query.Get("not_membership"))
+	}
+
+	// GetRoomMembers only sends at
+	if _, err := client.Room.GetRoomMembers(context.Background(), "!test-room:localhost", "s1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if query.Get("at") != "s1" || query.Has("membership") || query.Has("not_membership") {
+		t.Errorf("Expected only at=s1, got %v", query)
+	}
+}
+
 func TestSetRoomName(t *testing.T) {
 	mock := &MockHTTPClient{
 		Response: newMockResponse(200, nil),
@@ -467,8 +511,8 @@ func TestSetRoomName(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -479,6 +523,37 @@ func TestSetRoomName(t *testing.T) {
 	}
 }
 
+func TestSetRoomNameWithEventID(t *testing.T) {
+	var path string
+	var body map[string]string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.EscapedPath()
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"event_id": "$name-event"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	eventID, err := client.Room.SetRoomNameWithEventID(context.Background(), "!test-room:localhost", "New Room Name")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if eventID != "$name-event" {
+		t.Errorf("Expected event ID '$name-event', got '%s'", eventID)
+	}
+	if path != "/_matrix/client/r0/rooms/%21test-room:localhost/state/m.room.name" {
+		t.Errorf("Expected m.room.name state path, got '%s'", path)
+	}
+	if body["name"] != "New Room Name" {
+		t.Errorf("Expected name 'New Room Name', got '%s'", body["name"])
+	}
+}
+
 func TestSetRoomTopic(t *testing.T) {
 	mock := &MockHTTPClient{
 		Response: newMockResponse(200, nil),
@@ -488,8 +563,8 @@ func TestSetRoomTopic(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -509,8 +584,8 @@ func TestSetRoomAvatar(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -535,8 +610,8 @@ func TestGetJoinedRooms(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -573,8 +648,8 @@ func TestGetRoomPowerLevels(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -590,16 +665,20 @@ func TestGetRoomPowerLevels(t *testing.T) {
 }
 
 func TestSetRoomPowerLevels(t *testing.T) {
-	mock := &MockHTTPClient{
-		Response: newMockResponse(200, nil),
-	}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/account/whoami") {
+			return newMockResponse(200, map[string]string{"user_id": "@admin:localhost"}), nil
+		}
+		return newMockResponse(200, nil), nil
+	})
 
 	client := &Client{
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
+	client.Auth = &AuthAPI{client: client}
 
 	ctx := context.Background()
 
@@ -628,8 +707,8 @@ func TestGetRoomAliases(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -653,8 +732,8 @@ func TestForgetRoom(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -674,8 +753,8 @@ func TestDeleteRoom(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -686,6 +765,34 @@ func TestDeleteRoom(t *testing.T) {
 	}
 }
 
+func TestDeleteRoomPurgeFlag(t *testing.T) {
+	for _, purge := range []bool{true, false} {
+		var got string
+		mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodDelete {
+				t.Errorf("Expected DELETE, got %s", req.Method)
+			}
+			got = req.URL.Query().Get("purge")
+			return newMockResponse(200, nil), nil
+		})
+
+		client := &Client{
+			httpClient: mock,
+			baseURL:    "http://localhost:8008",
+			token:      "test-token",
+		}
+		client.Room = &RoomAPI{client: client}
+
+		if err := client.Room.DeleteRoom(context.Background(), "!test-room:localhost", purge); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if want := strconv.FormatBool(purge); got != want {
+			t.Errorf("Expected purge=%s, got purge=%s", want, got)
+		}
+	}
+}
+
 func TestCreateRoomDefaultValues(t *testing.T) {
 	mock := &MockHTTPClient{
 		Response: newMockResponse(200, map[string]string{
@@ -697,8 +804,8 @@ func TestCreateRoomDefaultValues(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -824,6 +931,141 @@ func TestMemberContent(t *testing.T) {
 	}
 }
 
+func TestGetUnreadCounts(t *testing.T) {
+	var filter map[string]interface{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		json.Unmarshal([]byte(req.URL.Query().Get("filter")), &filter)
+		return newMockResponse(200, map[string]interface{}{
+			"next_batch": "s1",
+			"rooms": map[string]interface{}{
+				"join": map[string]interface{}{
+					"!room:localhost": map[string]interface{}{
+						"unread_notifications": map[string]int{"highlight_count": 1, "notification_count": 4},
+					},
+				},
+			},
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+	client.Sync = &SyncAPI{client: client}
+
+	counts, err := client.Room.GetUnreadCounts(context.Background(), "!room:localhost")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if counts.HighlightCount != 1 || counts.NotificationCount != 4 {
+		t.Errorf("Expected 1 highlight and 4 notifications, got %+v", counts)
+	}
+
+	room, _ := filter["room"].(map[string]interface{})
+	if rooms, _ := room["rooms"].([]interface{}); len(rooms) != 1 || rooms[0] != "!room:localhost" {
+		t.Errorf("Expected sync filtered to !room:localhost, got %v", filter)
+	}
+
+	if _, err := client.Room.GetUnreadCounts(context.Background(), "!other:localhost"); err == nil {
+		t.Error("Expected error for a room missing from sync")
+	}
+}
+
+func TestPowerLevelsDiff(t *testing.T) {
+	before := &PowerLevels{
+		Users: map[string]int{
+			"@admin:localhost": 100,
+			"@alice:localhost": 0,
+			"@bob:localhost":   50,
+		},
+		Ban:  50,
+		Kick: 50,
+	}
+	after := &PowerLevels{
+		Users: map[string]int{
+			"@admin:localhost": 100,
+			"@alice:localhost": 50,
+			"@carol:localhost": 10,
+		},
+		Ban:  75,
+		Kick: 50,
+	}
+
+	diff := before.Diff(after)
+
+	// Promotion
+	if change, ok := diff.ChangedUsers["@alice:localhost"]; !ok || change.Old != 0 || change.New != 50 {
+		t.Errorf("Expected @alice:localhost promoted from 0 to 50, got %+v", diff.ChangedUsers)
+	}
+	if len(diff.ChangedUsers) != 1 {
+		t.Errorf("Expected 1 changed user, got %v", diff.ChangedUsers)
+	}
+
+	// Removed user
+	if level, ok := diff.RemovedUsers["@bob:localhost"]; !ok || level != 50 {
+		t.Errorf("Expected @bob:localhost removed at 50, got %v", diff.RemovedUsers)
+	}
+	if level, ok := diff.AddedUsers["@carol:localhost"]; !ok || level != 10 || len(diff.AddedUsers) != 1 {
+		t.Errorf("Expected @carol:localhost added at 10, got %v", diff.AddedUsers)
+	}
+
+	// Changed ban threshold
+	if change, ok := diff.ChangedThresholds["ban"]; !ok || change.Old != 50 || change.New != 75 {
+		t.Errorf("Expected ban threshold changed from 50 to 75, got %+v", diff.ChangedThresholds)
+	}
+	if len(diff.ChangedThresholds) != 1 {
+		t.Errorf("Expected 1 changed threshold, got %v", diff.ChangedThresholds)
+	}
+
+	if diff.Empty() {
+		t.Error("Expected diff not to be empty")
+	}
+	if !before.Diff(before).Empty() {
+		t.Error("Expected diff of identical power levels to be empty")
+	}
+}
+
+func TestMembership(t *testing.T) {
+	for _, m := range []Membership{MembershipJoin, MembershipLeave, MembershipInvite, MembershipBan, MembershipKnock} {
+		if !m.Valid() {
+			t.Errorf("Expected %s to be valid", m)
+		}
+
+		data, err := json.Marshal(MemberContent{Membership: m})
+		if err != nil {
+			t.Fatalf("Failed to marshal MemberContent: %v", err)
+		}
+		if want := `{"membership":"` + string(m) + `"}`; string(data) != want {
+			t.Errorf("Expected %s, got %s", want, data)
+		}
+
+		var parsed MemberContent
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			t.Fatalf("Failed to unmarshal MemberContent: %v", err)
+		}
+		if parsed.Membership != m {
+			t.Errorf("Expected membership '%s', got '%s'", m, parsed.Membership)
+		}
+	}
+
+	var parsed MemberEvent
+	err := json.Unmarshal([]byte(`{"type":"m.room.member","state_key":"@a:localhost","content":{"membership":"org.example.lurk"}}`), &parsed)
+	if err != nil {
+		t.Fatalf("Expected unknown membership to decode, got %v", err)
+	}
+	if parsed.Content.Membership != "org.example.lurk" {
+		t.Errorf("Expected membership 'org.example.lurk', got '%s'", parsed.Content.Membership)
+	}
+	if parsed.Content.Membership.Valid() {
+		t.Error("Expected unknown membership to be invalid")
+	}
+	if Membership("").Valid() {
+		t.Error("Expected empty membership to be invalid")
+	}
+}
+
 func TestRoomAPIErrorHandling(t *testing.T) {
 	mock := &MockHTTPClient{
 		Response: createMockResponse(400, ErrorResponse{
@@ -836,8 +1078,8 @@ func TestRoomAPIErrorHandling(t *testing.T) {
 		httpClient: mock,
 		baseURL:    "http://localhost:8008",
 		token:      "test-token",
-		Room:       &RoomAPI{client: client},
 	}
+	client.Room = &RoomAPI{client: client}
 
 	ctx := context.Background()
 
@@ -849,3 +1091,986 @@ func TestRoomAPIErrorHandling(t *testing.T) {
 		t.Fatal("Expected error for bad request")
 	}
 }
+
+// newRoomStateMock serves the given state contents by event type and
+// responds 404 for any state that is not set
+func newRoomStateMock(state map[string]interface{}, members []interface{}) MockHTTPFunc {
+	return MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/members") {
+			return newMockResponse(200, map[string]interface{}{"chunk": members}), nil
+		}
+		for eventType, content := range state {
+			if strings.HasSuffix(req.URL.Path, "/state/"+eventType) {
+				return newMockResponse(200, content), nil
+			}
+		}
+		return newMockResponse(404, ErrorResponse{Message: "Event not found."}), nil
+	})
+}
+
+func newMember(userID, displayName, membership string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "m.room.member",
+		"state_key": userID,
+		"content": map[string]string{
+			"membership":  membership,
+			"displayname": displayName,
+		},
+	}
+}
+
+func TestComputeDisplayName(t *testing.T) {
+	members := []interface{}{
+		newMember("@bot:localhost", "Bot", "join"),
+		newMember("@bob:localhost", "Bob", "join"),
+		newMember("@alice:localhost", "Alice", "join"),
+		newMember("@carol:localhost", "Carol", "leave"),
+	}
+
+	tests := []struct {
+		name     string
+		state    map[string]interface{}
+		members  []interface{}
+		expected string
+	}{
+		{
+			name: "named room",
+			state: map[string]interface{}{
+				"m.room.name":            map[string]string{"name": "Ops"},
+				"m.room.canonical_alias": map[string]string{"alias": "#ops:localhost"},
+			},
+			members:  members,
+			expected: "Ops",
+		},
+		{
+			name: "aliased room",
+			state: map[string]interface{}{
+				"m.room.canonical_alias": map[string]string{"alias": "#ops:localhost"},
+			},
+			members:  members,
+			expected: "#ops:localhost",
+		},
+		{
+			name:     "member derived",
+			state:    map[string]interface{}{},
+			members:  members,
+			expected: "Alice and Bob",
+		},
+		{
+			name:     "empty room",
+			state:    map[string]interface{}{},
+			members:  []interface{}{newMember("@bot:localhost", "Bot", "join")},
+			expected: "Empty room",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{
+				httpClient: newRoomStateMock(tt.state, tt.members),
+				baseURL:    "http://localhost:8008",
+				token:      "test-token",
+			}
+			client.Room = &RoomAPI{client: client}
+
+			name, err := client.Room.ComputeDisplayName(context.Background(), "!room:localhost", "@bot:localhost")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if name != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, name)
+			}
+		})
+	}
+}
+
+func TestAPIVersionPrefix(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{version: "", expected: "/_matrix/client/r0/createRoom"},
+		{version: "v3", expected: "/_matrix/client/v3/createRoom"},
+	}
+
+	for _, tt := range tests {
+		var path string
+		mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+			path = req.URL.Path
+			return newMockResponse(200, map[string]string{"room_id": "!room:localhost"}), nil
+		})
+
+		client, err := NewClient(&Config{
+			ServerAddress: "localhost:8008",
+			APIVersion:    tt.version,
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		client.httpClient = mock
+
+		if _, err := client.Room.CreateRoom(context.Background(), nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if path != tt.expected {
+			t.Errorf("Expected path '%s', got '%s'", tt.expected, path)
+		}
+	}
+}
+
+func TestAPIVersionAdminPathUnchanged(t *testing.T) {
+	var path string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		return newMockResponse(200, map[string]string{}), nil
+	})
+
+	client, err := NewClient(&Config{
+		ServerAddress: "localhost:8008",
+		APIVersion:    "v3",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	client.httpClient = mock
+
+	if _, err := client.Room.GetRoomDetails(context.Background(), "!room:localhost"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if path != "/_matrix/client/r0/admin/rooms/!room:localhost" {
+		t.Errorf("Expected admin path to keep r0, got '%s'", path)
+	}
+}
+
+func TestRoomDetailsCreateAt(t *testing.T) {
+	body := `{
+		"room_id": "!mscvqgqpHYjBGDxNym:matrix.org",
+		"name": "Music Theory",
+		"avatar": "mxc://matrix.org/AQDaVFlbkQoErdOgqWRgiGSV",
+		"topic": "Theory, Composition, Notation, Analysis",
+		"creator": "@foo:matrix.org",
+		"guest_can_join": false,
+		"join_rule": "invite",
+		"private": true,
+		"joined_members": 2,
+		"joined_local_members": 2,
+		"create_at": 1700000000000
+	}`
+
+	var details RoomDetailsResponse
+	if err := json.Unmarshal([]byte(body), &details); err != nil {
+		t.Fatalf("Failed to unmarshal RoomDetailsResponse: %v", err)
+	}
+	if details.Name != "Music Theory" || details.JoinedMembers != 2 {
+		t.Errorf("Unexpected room details %+v", details)
+	}
+	if !details.CreateAt.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("Expected creation time from milliseconds, got %v", details.CreateAt)
+	}
+
+	tests := []struct {
+		createAt string
+		expected time.Time
+	}{
+		{`1700000000`, time.Unix(1700000000, 0)},
+		{`"2023-11-14T22:13:20Z"`, time.Unix(1700000000, 0)},
+		{`0`, time.Time{}},
+		{`null`, time.Time{}},
+	}
+	for _, tt := range tests {
+		var details RoomDetailsResponse
+		if err := json.Unmarshal([]byte(`{"create_at":`+tt.createAt+`}`), &details); err != nil {
+			t.Fatalf("Failed to unmarshal create_at %s: %v", tt.createAt, err)
+		}
+		if !details.CreateAt.Equal(tt.expected) {
+			t.Errorf("Expected create_at %s to decode to %v, got %v", tt.createAt, tt.expected, details.CreateAt)
+		}
+	}
+}
+
+func TestCreateRoomPresetValidation(t *testing.T) {
+	tests := []struct {
+		preset   string
+		expected string
+		wantErr  bool
+	}{
+		{preset: "", expected: PresetPrivateChat},
+		{preset: PresetPrivateChat, expected: PresetPrivateChat},
+		{preset: PresetPublicChat, expected: PresetPublicChat},
+		{preset: PresetTrustedPrivateChat, expected: PresetTrustedPrivateChat},
+		{preset: " Public_Chat ", expected: PresetPublicChat},
+		{preset: "public", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			var sent string
+			requests := 0
+			mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+				requests++
+				var body CreateRoomRequest
+				json.NewDecoder(req.Body).Decode(&body)
+				sent = body.Preset
+				return newMockResponse(200, map[string]string{"room_id": "!room:localhost"}), nil
+			})
+
+			client := &Client{
+				httpClient: mock,
+				baseURL:    "http://localhost:8008",
+				token:      "test-token",
+			}
+			client.Room = &RoomAPI{client: client}
+
+			_, err := client.Room.CreateRoom(context.Background(), &CreateRoomRequest{Preset: tt.preset})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected error for invalid preset")
+				}
+				if !strings.Contains(err.Error(), "public_chat") {
+					t.Errorf("Expected error to list valid presets, got '%v'", err)
+				}
+				if requests != 0 {
+					t.Errorf("Expected no request for invalid preset, got %d", requests)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if sent != tt.expected {
+				t.Errorf("Expected preset '%s', got '%s'", tt.expected, sent)
+			}
+		})
+	}
+}
+
+func TestJoinRoomEscapesAlias(t *testing.T) {
+	var escapedPath, path string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		escapedPath = req.URL.EscapedPath()
+		path = req.URL.Path
+		return newMockResponse(200, map[string]interface{}{
+			"room_id": "!joined:localhost",
+			"servers": []string{"localhost"},
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	resp, err := client.Room.JoinRoom(context.Background(), "#dev?ops:localhost", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if escapedPath != "/_matrix/client/r0/join/%23dev%3Fops:localhost" {
+		t.Errorf("Expected escaped alias in path, got '%s'", escapedPath)
+	}
+	if path != "/_matrix/client/r0/join/#dev?ops:localhost" {
+		t.Errorf("Expected decoded path to contain alias, got '%s'", path)
+	}
+	if resp.RoomID != "!joined:localhost" {
+		t.Errorf("Expected room ID '!joined:localhost', got '%s'", resp.RoomID)
+	}
+	if len(resp.Servers) != 1 || resp.Servers[0] != "localhost" {
+		t.Errorf("Expected servers [localhost], got %v", resp.Servers)
+	}
+}
+
+func TestRoomPathSegmentsEscaped(t *testing.T) {
+	var paths []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.URL.EscapedPath())
+		return newMockResponse(200, map[string]string{"room_id": "!room:server"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	ctx := context.Background()
+	client.Room.JoinRoom(ctx, "#a/b:server", nil)
+	client.Room.LeaveRoom(ctx, "!a b/c:server", nil)
+	client.Room.GetRoomState(ctx, "!room:server", "m.room.member", "@user/x:server")
+
+	expected := []string{
+		"/_matrix/client/r0/join/%23a%2Fb:server",
+		"/_matrix/client/r0/rooms/%21a%20b%2Fc:server/leave",
+		"/_matrix/client/r0/rooms/%21room:server/state/m.room.member/@user%2Fx:server",
+	}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %d requests, got %d", len(expected), len(paths))
+	}
+	for i, path := range paths {
+		if path != expected[i] {
+			t.Errorf("Expected path '%s', got '%s'", expected[i], path)
+		}
+	}
+}
+
+func TestCanSend(t *testing.T) {
+	requests := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return newMockResponse(200, map[string]interface{}{
+			"users": map[string]int{
+				"@admin:localhost": 100,
+				"@mod:localhost":   50,
+			},
+			"users_default":  0,
+			"events":         map[string]int{"m.room.power_levels": 100},
+			"events_default": 0,
+			"state_default":  50,
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	tests := []struct {
+		userID    string
+		eventType string
+		state     bool
+		expected  bool
+	}{
+		{"@mod:localhost", "m.room.topic", true, true},
+		{"@user:localhost", "m.room.topic", true, false},
+		{"@user:localhost", "m.room.message", false, true},
+		{"@mod:localhost", "m.room.power_levels", true, false},
+		{"@admin:localhost", "m.room.power_levels", true, true},
+	}
+
+	for _, tt := range tests {
+		ok, err := client.Room.CanSend(context.Background(), "!room:localhost", tt.userID, tt.eventType, tt.state)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if ok != tt.expected {
+			t.Errorf("CanSend(%s, %s, %v): expected %v, got %v", tt.userID, tt.eventType, tt.state, tt.expected, ok)
+		}
+	}
+
+	if requests != len(tests) {
+		t.Errorf("Expected one power levels fetch per call, got %d for %d calls", requests, len(tests))
+	}
+}
+
+func TestGetJoinedRoomsWithType(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/_matrix/client/r0/joined_rooms":
+			return newMockResponse(200, map[string]interface{}{
+				"joined_rooms": []string{"!space:localhost", "!room:localhost"},
+			}), nil
+		case "/_matrix/client/r0/rooms/!space:localhost/state/m.room.create":
+			return newMockResponse(200, map[string]string{"creator": "@bot:localhost", "type": RoomTypeSpace}), nil
+		case "/_matrix/client/r0/rooms/!room:localhost/state/m.room.create":
+			return newMockResponse(200, map[string]string{"creator": "@bot:localhost"}), nil
+		}
+		return newMockResponse(404, ErrorResponse{Message: "Event not found."}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	rooms, err := client.Room.GetJoinedRoomsWithType(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []JoinedRoom{
+		{ID: "!space:localhost", Type: RoomTypeSpace},
+		{ID: "!room:localhost", Type: ""},
+	}
+	if len(rooms) != len(expected) {
+		t.Fatalf("Expected %d rooms, got %d", len(expected), len(rooms))
+	}
+	for i, room := range rooms {
+		if room != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], room)
+		}
+	}
+}
+
+func TestCreateSpace(t *testing.T) {
+	var body map[string]interface{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		body = nil
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"room_id": "!space:localhost"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	if _, err := client.Room.CreateSpace(context.Background(), "Team", "Team space"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	content, _ := body["creation_content"].(map[string]interface{})
+	if content["type"] != RoomTypeSpace {
+		t.Errorf("Expected creation_content.type '%s', got %v", RoomTypeSpace, body["creation_content"])
+	}
+	if body["name"] != "Team" {
+		t.Errorf("Expected name 'Team', got %v", body["name"])
+	}
+	if _, ok := body["RoomType"]; ok {
+		t.Error("Expected RoomType not to be serialized as a top-level field")
+	}
+
+	// RoomType merges with caller-provided creation content without
+	// writing into the caller's request
+	creation := map[string]interface{}{"m.federate": false}
+	req := &CreateRoomRequest{
+		RoomType:        RoomTypeSpace,
+		CreationContent: creation,
+	}
+	_, err := client.Room.CreateRoom(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	content, _ = body["creation_content"].(map[string]interface{})
+	if content["type"] != RoomTypeSpace || content["m.federate"] != false {
+		t.Errorf("Expected merged creation content, got %v", content)
+	}
+	if len(creation) != 1 || len(req.CreationContent) != 1 {
+		t.Errorf("Expected caller's creation content to be unchanged, got %v", req.CreationContent)
+	}
+	if req.Preset != "" || req.Visibility != "" {
+		t.Errorf("Expected caller's request to be unchanged, got %+v", req)
+	}
+}
+
+func TestGetAllRoomState(t *testing.T) {
+	var path string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		return newMockResponse(200, []map[string]interface{}{
+			{"type": "m.room.create", "state_key": "", "content": map[string]string{"creator": "@bot:localhost"}},
+			{"type": "m.room.name", "state_key": "", "content": map[string]string{"name": "Ops"}},
+			{"type": "m.room.member", "state_key": "@bot:localhost", "content": map[string]string{"membership": "join"}},
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	events, err := client.Room.GetAllRoomState(context.Background(), "!room:localhost")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/rooms/!room:localhost/state" {
+		t.Errorf("Expected path '/_matrix/client/r0/rooms/!room:localhost/state', got '%s'", path)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 state events, got %d", len(events))
+	}
+	if events[1].Type != "m.room.name" {
+		t.Errorf("Expected second event 'm.room.name', got '%s'", events[1].Type)
+	}
+	if events[2].StateKey != "@bot:localhost" {
+		t.Errorf("Expected member state key '@bot:localhost', got '%s'", events[2].StateKey)
+	}
+	content, _ := events[1].Content.(map[string]interface{})
+	if content["name"] != "Ops" {
+		t.Errorf("Expected name content 'Ops', got %v", events[1].Content)
+	}
+}
+
+func TestRoomPermalink(t *testing.T) {
+	room := &RoomAPI{}
+
+	link := room.RoomPermalink("!room:example.org", []string{"example.org", "other.org"})
+	if link != "https://matrix.to/#/%21room:example.org?via=example.org&via=other.org" {
+		t.Errorf("Unexpected room permalink '%s'", link)
+	}
+
+	link = room.RoomPermalink("#ops/team:example.org", nil)
+	if link != "https://matrix.to/#/%23ops%2Fteam:example.org" {
+		t.Errorf("Unexpected alias permalink '%s'", link)
+	}
+}
+
+func TestSetRoomState(t *testing.T) {
+	var method, path string
+	var body map[string]interface{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		method = req.Method
+		path = req.URL.Path
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"event_id": "$state-event"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	resp, err := client.Room.SetRoomState(context.Background(), "!room:localhost", "com.example.config", "bot-settings",
+		map[string]interface{}{"enabled": true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if method != http.MethodPut {
+		t.Errorf("Expected PUT, got %s", method)
+	}
+	if path != "/_matrix/client/r0/rooms/!room:localhost/state/com.example.config/bot-settings" {
+		t.Errorf("Expected path with state key, got '%s'", path)
+	}
+	if body["enabled"] != true {
+		t.Errorf("Expected content to be forwarded, got %v", body)
+	}
+	if resp.EventID != "$state-event" {
+		t.Errorf("Expected event ID '$state-event', got '%s'", resp.EventID)
+	}
+}
+
+func TestExportImportState(t *testing.T) {
+	var writes []string
+	var written []map[string]interface{}
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == "GET" {
+			return newMockResponse(200, []map[string]interface{}{
+				{"type": "m.room.create", "state_key": "", "content": map[string]string{"creator": "@admin:localhost"}},
+				{"type": "m.room.member", "state_key": "@admin:localhost", "content": map[string]string{"membership": "join"}},
+				{"type": "m.room.power_levels", "state_key": "", "content": map[string]interface{}{"users": map[string]int{"@admin:localhost": 100}}},
+				{"type": "m.room.name", "state_key": "", "content": map[string]string{"name": "Backup"}},
+				{"type": "m.room.topic", "state_key": "", "content": map[string]string{"topic": "Restored topic"}},
+			}), nil
+		}
+
+		writes = append(writes, req.Method+" "+req.URL.EscapedPath())
+		var body map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&body)
+		written = append(written, body)
+		return newMockResponse(200, map[string]string{"event_id": "$e"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	events, err := client.Room.ExportState(context.Background(), "!old:localhost")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("Expected 5 state events, got %d", len(events))
+	}
+
+	if err := client.Room.ImportState(context.Background(), "!new:localhost", events); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{
+		"PUT /_matrix/client/r0/rooms/%21new:localhost/state/m.room.name",
+		"PUT /_matrix/client/r0/rooms/%21new:localhost/state/m.room.topic",
+		"PUT /_matrix/client/r0/rooms/%21new:localhost/state/m.room.power_levels",
+	}
+	if len(writes) != len(expected) {
+		t.Fatalf("Expected %d writes, got %v", len(expected), writes)
+	}
+	for i := range expected {
+		if writes[i] != expected[i] {
+			t.Errorf("Expected '%s', got '%s'", expected[i], writes[i])
+		}
+	}
+	if written[0]["name"] != "Backup" || written[1]["topic"] != "Restored topic" {
+		t.Errorf("Expected name and topic to be replayed, got %v", written)
+	}
+	users, _ := written[2]["users"].(map[string]interface{})
+	if users["@admin:localhost"] != float64(100) {
+		t.Errorf("Expected power levels to be replayed, got %v", written[2])
+	}
+}
+
+func TestRoomAliases(t *testing.T) {
+	type call struct {
+		method string
+		path   string
+		body   map[string]string
+	}
+	var calls []call
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		var body map[string]string
+		if req.Body != nil {
+			json.NewDecoder(req.Body).Decode(&body)
+		}
+		calls = append(calls, call{req.Method, req.URL.EscapedPath(), body})
+		return newMockResponse(200, map[string]interface{}{
+			"room_id": "!room:example.org",
+			"servers": []string{"example.org", "other.org"},
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	ctx := context.Background()
+	alias := "#ops:example.org"
+	escaped := "/_matrix/client/r0/directory/room/%23ops:example.org"
+
+	if err := client.Room.CreateAlias(ctx, alias, "!room:example.org"); err != nil {
+		t.Fatalf("Expected no error on CreateAlias, got %v", err)
+	}
+	resp, err := client.Room.ResolveAlias(ctx, alias)
+	if err != nil {
+		t.Fatalf("Expected no error on ResolveAlias, got %v", err)
+	}
+	if err := client.Room.DeleteAlias(ctx, alias); err != nil {
+		t.Fatalf("Expected no error on DeleteAlias, got %v", err)
+	}
+
+	expected := []string{http.MethodPut, http.MethodGet, http.MethodDelete}
+	for i, c := range calls {
+		if c.method != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], c.method)
+		}
+		if c.path != escaped {
+			t.Errorf("Expected path '%s', got '%s'", escaped, c.path)
+		}
+	}
+	if calls[0].body["room_id"] != "!room:example.org" {
+		t.Errorf("Expected room_id in CreateAlias body, got %v", calls[0].body)
+	}
+	if resp.RoomID != "!room:example.org" || len(resp.Servers) != 2 {
+		t.Errorf("Expected resolved room and servers, got %+v", resp)
+	}
+}
+
+func TestUpgradeRoom(t *testing.T) {
+	var path string
+	var body map[string]string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.Path
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"replacement_room": "!new:localhost"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	resp, err := client.Room.UpgradeRoom(context.Background(), "!old:localhost", "10")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/rooms/!old:localhost/upgrade" {
+		t.Errorf("Expected upgrade path, got '%s'", path)
+	}
+	if body["new_version"] != "10" {
+		t.Errorf("Expected new_version '10', got %v", body)
+	}
+	if resp.ReplacementRoom != "!new:localhost" {
+		t.Errorf("Expected replacement room '!new:localhost', got '%s'", resp.ReplacementRoom)
+	}
+}
+
+func TestKnockRoom(t *testing.T) {
+	var path string
+	var servers []string
+	var body map[string]string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.EscapedPath()
+		servers = req.URL.Query()["server_name"]
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]string{"room_id": "!knocked:example.org"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	resp, err := client.Room.KnockRoom(context.Background(), "#private:example.org", "Let me in", []string{"example.org", "other.org"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if path != "/_matrix/client/r0/knock/%23private:example.org" {
+		t.Errorf("Expected knock path, got '%s'", path)
+	}
+	if len(servers) != 2 || servers[0] != "example.org" || servers[1] != "other.org" {
+		t.Errorf("Expected server_name hints [example.org other.org], got %v", servers)
+	}
+	if body["reason"] != "Let me in" {
+		t.Errorf("Expected reason 'Let me in', got %v", body)
+	}
+	if resp.RoomID != "!knocked:example.org" {
+		t.Errorf("Expected room ID '!knocked:example.org', got '%s'", resp.RoomID)
+	}
+}
+
+func TestPowerLevelsSpecDefaults(t *testing.T) {
+	var levels PowerLevels
+	if err := json.Unmarshal([]byte(`{"users":{"@a:x":10}}`), &levels); err != nil {
+		t.Fatalf("Failed to unmarshal PowerLevels: %v", err)
+	}
+
+	if levels.CanSendEvent("@a:x", "m.room.topic", true) {
+		t.Error("Expected state events to be denied below the default state_default of 50")
+	}
+	if !levels.CanSendEvent("@a:x", "m.room.message", false) {
+		t.Error("Expected messages to be allowed at the default events_default of 0")
+	}
+	if levels.StateDefault != 50 || levels.Ban != 50 || levels.Kick != 50 || levels.Redact != 50 || levels.Invite != 0 {
+		t.Errorf("Expected spec defaults, got %+v", levels)
+	}
+	if defaults := NewPowerLevels(); defaults.StateDefault != 50 || defaults.Ban != 50 || defaults.Kick != 50 || defaults.Redact != 50 || defaults.Invite != 0 {
+		t.Errorf("Expected NewPowerLevels to hold the spec defaults, got %+v", defaults)
+	}
+
+	// Explicit values, including 0, win over the defaults
+	if err := json.Unmarshal([]byte(`{"state_default":0,"ban":100}`), &levels); err != nil {
+		t.Fatalf("Failed to unmarshal PowerLevels: %v", err)
+	}
+	if levels.StateDefault != 0 || levels.Ban != 100 {
+		t.Errorf("Expected explicit state_default 0 and ban 100, got %+v", levels)
+	}
+}
+
+func TestSetRoomPowerLevelsLockoutMatchesServer(t *testing.T) {
+	var sent []byte
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/account/whoami") {
+			return newMockResponse(200, map[string]string{"user_id": "@bot:localhost"}), nil
+		}
+		sent, _ = io.ReadAll(req.Body)
+		return newMockResponse(200, map[string]string{"event_id": "$levels"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+	client.Auth = &AuthAPI{client: client}
+
+	ctx := context.Background()
+
+	// A user at 10 below the default state_default of 50 is locked out
+	defaults := NewPowerLevels()
+	defaults.Users = map[string]int{"@bot:localhost": 10, "@admin:localhost": 100}
+	if err := client.Room.SetRoomPowerLevels(ctx, "!room:localhost", defaults); err == nil {
+		t.Error("Expected a user at 10 to be rejected under the default state_default")
+	}
+	if sent != nil {
+		t.Errorf("Expected rejected power levels not to be sent, got %s", sent)
+	}
+
+	// A zero-valued literal is accepted only because its zero state_default
+	// is sent as such; the server must reach the same verdict
+	literal := &PowerLevels{Users: map[string]int{"@bot:localhost": 10}}
+	if err := client.Room.SetRoomPowerLevels(ctx, "!room:localhost", literal); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var server PowerLevels
+	if err := json.Unmarshal(sent, &server); err != nil {
+		t.Fatalf("Failed to unmarshal sent power levels: %v", err)
+	}
+	if !server.CanSendEvent("@bot:localhost", "m.room.power_levels", true) {
+		t.Errorf("Expected the server to let @bot:localhost change power levels, sent %s", sent)
+	}
+}
+
+func TestPowerLevelsRoundTrip(t *testing.T) {
+	var levels PowerLevels
+	if err := json.Unmarshal([]byte(`{"users":{"@a:x":100},"state_default":0,"ban":0}`), &levels); err != nil {
+		t.Fatalf("Failed to unmarshal PowerLevels: %v", err)
+	}
+	levels.Users["@b:x"] = 10
+
+	data, err := json.Marshal(&levels)
+	if err != nil {
+		t.Fatalf("Failed to marshal PowerLevels: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Failed to unmarshal encoded PowerLevels: %v", err)
+	}
+	for _, key := range []string{"state_default", "ban"} {
+		if value, ok := fields[key]; !ok || value != float64(0) {
+			t.Errorf("Expected explicit %s 0 to be preserved, got %s", key, data)
+		}
+	}
+
+	var decoded PowerLevels
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal encoded PowerLevels: %v", err)
+	}
+	if decoded.StateDefault != 0 || decoded.Ban != 0 || decoded.Kick != 50 || decoded.Redact != 50 {
+		t.Errorf("Expected levels to survive a round trip, got %+v", decoded)
+	}
+}
+
+func TestPowerLevelsValidate(t *testing.T) {
+	valid := &PowerLevels{
+		Users:         map[string]int{"@admin:localhost": 100},
+		UsersDefault:  0,
+		EventsDefault: 0,
+		StateDefault:  50,
+		Events:        map[string]int{"m.room.power_levels": 100},
+		Ban:           50,
+		Kick:          50,
+		Redact:        50,
+		Invite:        0,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid power levels, got %v", err)
+	}
+
+	invalid := map[string]*PowerLevels{
+		"negative user":    {Users: map[string]int{"@admin:localhost": 100, "@bad:localhost": -1}},
+		"negative ban":     {Users: map[string]int{"@admin:localhost": 100}, Ban: -50},
+		"negative event":   {Users: map[string]int{"@admin:localhost": 100}, Events: map[string]int{"m.room.name": -10}},
+		"nobody can admin": {Users: map[string]int{"@mod:localhost": 50}, Events: map[string]int{"m.room.power_levels": 100}},
+		"state default":    {Users: map[string]int{"@mod:localhost": 50}, StateDefault: 75},
+		"spec defaults":    {Users: map[string]int{"@mod:localhost": 10}, StateDefault: 50, Ban: 50, Kick: 50, Redact: 50},
+	}
+	for name, levels := range invalid {
+		if err := levels.Validate(); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+
+	// A zero state_default passes only because it is sent as 0
+	zero := &PowerLevels{Users: map[string]int{"@mod:localhost": 10}}
+	if err := zero.Validate(); err != nil {
+		t.Errorf("Expected zero state_default to be valid, got %v", err)
+	}
+	data, err := json.Marshal(zero)
+	if err != nil {
+		t.Fatalf("Failed to marshal PowerLevels: %v", err)
+	}
+	if !strings.Contains(string(data), `"state_default":0`) {
+		t.Errorf("Expected state_default 0 to be sent, got %s", data)
+	}
+}
+
+func TestSetRoomPowerLevelsValidates(t *testing.T) {
+	requests := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return newMockResponse(200, map[string]string{"user_id": "@bot:localhost"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+	client.Auth = &AuthAPI{client: client}
+
+	err := client.Room.SetRoomPowerLevels(context.Background(), "!room:localhost", &PowerLevels{
+		Users: map[string]int{"@bot:localhost": 100},
+		Kick:  -1,
+	})
+	if err == nil {
+		t.Error("Expected invalid power levels to be rejected")
+	}
+	if requests != 0 {
+		t.Errorf("Expected no requests, got %d", requests)
+	}
+}
+
+func TestSetRoomPowerLevelsSelfLockout(t *testing.T) {
+	var methods []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		methods = append(methods, req.Method)
+		if strings.HasSuffix(req.URL.Path, "/account/whoami") {
+			return newMockResponse(200, map[string]string{"user_id": "@bot:localhost"}), nil
+		}
+		return newMockResponse(200, map[string]string{"event_id": "$levels"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+	client.Auth = &AuthAPI{client: client}
+
+	ctx := context.Background()
+
+	// Demoting ourselves below the power_levels requirement is refused
+	lockout := &PowerLevels{
+		Users:        map[string]int{"@bot:localhost": 50, "@admin:localhost": 100},
+		StateDefault: 50,
+		Events:       map[string]int{"m.room.power_levels": 100},
+	}
+	if err := client.Room.SetRoomPowerLevels(ctx, "!room:localhost", lockout); err == nil {
+		t.Error("Expected self-lockout to be rejected")
+	}
+	for _, method := range methods {
+		if method == http.MethodPut {
+			t.Error("Expected rejected power levels not to be sent")
+		}
+	}
+
+	// Keeping enough power is allowed
+	methods = nil
+	safe := &PowerLevels{
+		Users:        map[string]int{"@bot:localhost": 100, "@mod:localhost": 50},
+		StateDefault: 50,
+		Events:       map[string]int{"m.room.power_levels": 100},
+	}
+	if err := client.Room.SetRoomPowerLevels(ctx, "!room:localhost", safe); err != nil {
+		t.Errorf("Expected safe change to succeed, got %v", err)
+	}
+	if len(methods) != 2 || methods[1] != http.MethodPut {
+		t.Errorf("Expected whoami then PUT, got %v", methods)
+	}
+
+	// The unchecked variant sends without consulting whoami
+	methods = nil
+	if err := client.Room.SetRoomPowerLevelsUnchecked(ctx, "!room:localhost", lockout); err != nil {
+		t.Errorf("Expected unchecked change to succeed, got %v", err)
+	}
+	if len(methods) != 1 || methods[0] != http.MethodPut {
+		t.Errorf("Expected a single PUT, got %v", methods)
+	}
+}
@@ -0,0 +1,616 @@
+package taibai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ephemeral event types
+const (
+	EventTypeTyping  = "m.typing"
+	EventTypeReceipt = "m.receipt"
+)
+
+// SyncResponse represents the response from /sync
+type SyncResponse struct {
+	// NextBatch is the token to pass as since in the next sync
+	NextBatch string `json:"next_batch"`
+
+	// Rooms contains the per-room updates
+	Rooms SyncRooms `json:"rooms"`
+}
+
+// SyncRooms groups room updates by membership
+type SyncRooms struct {
+	// Join contains updates for rooms the user has joined, keyed by room ID
+	Join map[string]SyncJoinedRoom `json:"join,omitempty"`
+
+	// Invite contains rooms the user has been invited to, keyed by room ID
+	Invite map[string]SyncInvitedRoom `json:"invite,omitempty"`
+
+	// Leave contains rooms the user has left or been removed from, keyed by room ID
+	Leave map[string]SyncLeftRoom `json:"leave,omitempty"`
+}
+
+// SyncJoinedRoom represents the updates for a single joined room
+type SyncJoinedRoom struct {
+	// Timeline contains the new timeline events
+	Timeline SyncTimeline `json:"timeline"`
+
+	// State contains state updates preceding the timeline
+	State SyncEventList `json:"state"`
+
+	// Ephemeral contains non-persisted events such as typing and receipts
+	Ephemeral SyncEventList `json:"ephemeral"`
+
+	// AccountData contains the user's account data for the room
+	AccountData SyncAccountData `json:"account_data"`
+
+	// UnreadNotifications contains the unread notification counts of the room
+	UnreadNotifications UnreadNotificationCounts `json:"unread_notifications"`
+}
+
+// UnreadNotificationCounts represents the unread notification counts of a room
+type UnreadNotificationCounts struct {
+	// HighlightCount is the number of unread highlighted notifications, e.g. mentions
+	HighlightCount int `json:"highlight_count"`
+
+	// NotificationCount is the total number of unread notifications
+	NotificationCount int `json:"notification_count"`
+}
+
+// SyncAccountData is a list of account data events
+type SyncAccountData struct {
+	// Events is the list of account data events
+	Events []AccountDataEvent `json:"events"`
+}
+
+// AccountDataEvent is a global or per-room account data event
+type AccountDataEvent struct {
+	// Type is the account data type
+	Type string `json:"type"`
+
+	// Content is the raw account data
+	Content json.RawMessage `json:"content"`
+}
+
+// SyncLeftRoom represents the final updates for a room the user left
+type SyncLeftRoom struct {
+	// Timeline contains the timeline up to the point the user left
+	Timeline SyncTimeline `json:"timeline"`
+
+	// State contains state updates preceding the timeline
+	State SyncEventList `json:"state"`
+}
+
+// SyncInvitedRoom represents a pending invite
+type SyncInvitedRoom struct {
+	// InviteState contains the stripped state of the room
+	InviteState SyncStrippedState `json:"invite_state"`
+}
+
+// SyncStrippedState is a list of stripped state events
+type SyncStrippedState struct {
+	// Events contains the stripped state events
+	Events []StrippedStateEvent `json:"events"`
+}
+
+// StrippedStateEvent is a state event with only the fields needed to preview a room
+type StrippedStateEvent struct {
+	// Type is the type of the event
+	Type string `json:"type"`
+
+	// StateKey is the key of the state
+	StateKey string `json:"state_key"`
+
+	// Sender is the sender of the event
+	Sender string `json:"sender"`
+
+	// Content is the content of the event
+	Content map[string]interface{} `json:"content"`
+}
+
+// SyncTimeline represents the timeline section of a room
+type SyncTimeline struct {
+	// Events contains the timeline events
+	Events []MessageEvent `json:"events"`
+
+	// Limited indicates the timeline was truncated
+	Limited bool `json:"limited,omitempty"`
+
+	// PrevBatch is the token to paginate backwards from the timeline
+	PrevBatch string `json:"prev_batch,omitempty"`
+}
+
+// SyncEventList is a list of events in a sync section
+type SyncEventList struct {
+	// Events contains the events
+	Events []MessageEvent `json:"events"`
+}
+
+// SyncAPI handles the /sync long-polling endpoint
+type SyncAPI struct {
+	client *Client
+}
+
+// SyncRequest represents the parameters of a sync
+type SyncRequest struct {
+	// Since is the next_batch token of a previous sync (empty for an initial sync)
+	Since string
+
+	// Timeout is how long the server may wait for new events (0 returns immediately)
+	Timeout time.Duration
+
+	// Filter is a filter ID or an inline JSON filter (optional)
+	Filter string
+
+	// FullState requests the full state of every room even when Since is set
+	FullState bool
+}
+
+// Sync fetches the events since the given token
+func (s *SyncAPI) Sync(ctx context.Context, req *SyncRequest) (*SyncResponse, error) {
+	if req == nil {
+		req = &SyncRequest{}
+	}
+
+	query := map[string]string{
+		"timeout": strconv.FormatInt(req.Timeout.Milliseconds(), 10),
+	}
+	if req.Since != "" {
+		query["since"] = req.Since
+	}
+	if req.Filter != "" {
+		query["filter"] = req.Filter
+	}
+	if req.FullState {
+		query["full_state"] = "true"
+	}
+
+	result := &SyncResponse{}
+	err := s.client.doJSON(ctx, &Request{
+		Method:  http.MethodGet,
+		Path:    s.client.apiPrefix() + "/sync",
+		Query:   query,
+		Timeout: req.Timeout + syncRequestMargin,
+	}, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SyncLoop syncs repeatedly, threading next_batch between calls, and passes
+// every response to fn. It returns when fn or a sync fails, or ctx ends.
+func (s *SyncAPI) SyncLoop(ctx context.Context, fn func(resp *SyncResponse) error) error {
+	since := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		resp, err := s.Sync(ctx, &SyncRequest{
+			Since:   since,
+			Timeout: DefaultSyncTimeout,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := fn(resp); err != nil {
+			return err
+		}
+		since = resp.NextBatch
+	}
+}
+
+// TypingEvent represents an m.typing ephemeral event
+type TypingEvent struct {
+	// RoomID is the room the users are typing in
+	RoomID string `json:"room_id"`
+
+	// UserIDs is the list of users currently typing
+	UserIDs []string `json:"user_ids"`
+}
+
+// ReceiptEvent represents an m.receipt ephemeral event
+type ReceiptEvent struct {
+	// RoomID is the room the receipts belong to
+	RoomID string `json:"room_id"`
+
+	// Receipts is the flattened list of receipts
+	Receipts []Receipt `json:"receipts"`
+}
+
+// Receipt represents a single user's receipt for an event
+type Receipt struct {
+	// EventID is the event that was acknowledged
+	EventID string `json:"event_id"`
+
+	// Type is the receipt type (e.g., "m.read")
+	Type string `json:"type"`
+
+	// UserID is the user who sent the receipt
+	UserID string `json:"user_id"`
+
+	// Timestamp is when the receipt was sent, in milliseconds
+	Timestamp int64 `json:"ts,omitempty"`
+
+	// ThreadID is the thread the receipt applies to (optional)
+	ThreadID string `json:"thread_id,omitempty"`
+}
+
+// InviteEvent describes an invite derived from the stripped state
+type InviteEvent struct {
+	// RoomID is the room the user was invited to
+	RoomID string `json:"room_id"`
+
+	// Inviter is the user who sent the invite
+	Inviter string `json:"inviter,omitempty"`
+
+	// RoomName is the name of the room (if set)
+	RoomName string `json:"room_name,omitempty"`
+
+	// JoinRule is the join rule of the room (if known)
+	JoinRule string `json:"join_rule,omitempty"`
+
+	// State contains the full stripped state
+	State []StrippedStateEvent `json:"state,omitempty"`
+}
+
+// newInviteEvent extracts the invite details from stripped state
+func newInviteEvent(roomID string, room *SyncInvitedRoom) *InviteEvent {
+	invite := &InviteEvent{
+		RoomID: roomID,
+		State:  room.InviteState.Events,
+	}
+	for _, event := range room.InviteState.Events {
+		switch event.Type {
+		case "m.room.name":
+			invite.RoomName, _ = event.Content["name"].(string)
+		case "m.room.join_rules":
+			invite.JoinRule, _ = event.Content["join_rule"].(string)
+		case "m.room.member":
+			if membership, _ := event.Content["membership"].(string); Membership(membership) == MembershipInvite {
+				invite.Inviter = event.Sender
+			}
+		}
+	}
+	return invite
+}
+
+// DefaultSyncTimeout is the default long-poll timeout of the Syncer
+const DefaultSyncTimeout = 30 * time.Second
+
+// syncRequestMargin is added to the long-poll timeout to form the request
+// deadline, leaving the server time to answer an idle poll
+const syncRequestMargin = 10 * time.Second
+
+// Syncer polls /sync and dispatches the contents of sync responses to typed handlers
+type Syncer struct {
+	client *Client
+
+	// SyncTimeout is how long the server may hold a sync request open
+	// waiting for events (default: DefaultSyncTimeout)
+	SyncTimeout time.Duration
+
+	// Since is the next_batch token of the last processed sync
+	Since string
+
+	// TypingHandlers are called for every m.typing event
+	TypingHandlers []func(event *TypingEvent)
+
+	// ReceiptHandlers are called for every m.receipt event
+	ReceiptHandlers []func(event *ReceiptEvent)
+
+	// InviteHandlers are called for every pending invite
+	InviteHandlers []func(event *InviteEvent)
+
+	// JoinHandlers are called after an invite was accepted automatically
+	JoinHandlers []func(resp *JoinRoomResponse)
+
+	// ErrorHandlers are called by Run when dispatching a sync response
+	// failed, e.g. a rejected auto-join; the sync stream continues
+	ErrorHandlers []func(err error)
+
+	// RetryDelay is the initial backoff of Run after a failed sync, doubled
+	// on consecutive failures up to maxSyncRetryDelay (default: 1 second)
+	RetryDelay time.Duration
+
+	// AutoAcceptInvites decides whether an invite is joined automatically (optional)
+	AutoAcceptInvites func(invite InviteEvent) bool
+
+	// accepted records rooms already joined through AutoAcceptInvites so
+	// repeated invites for the same room do not trigger another join. A room
+	// is forgotten once it shows up under rooms.leave, so a later re-invite
+	// is accepted again.
+	accepted   map[string]bool
+	acceptedMu sync.Mutex
+
+	// watchers observe timeline events; they may be added while Run is
+	// running, unlike the handler slices above
+	watchers    map[int]func(roomID string, event *MessageEvent)
+	nextWatcher int
+	watchersMu  sync.Mutex
+}
+
+// NewSyncer creates a new Syncer bound to the client
+func NewSyncer(client *Client) *Syncer {
+	return &Syncer{
+		client:          client,
+		SyncTimeout:     DefaultSyncTimeout,
+		TypingHandlers:  make([]func(event *TypingEvent), 0),
+		ReceiptHandlers: make([]func(event *ReceiptEvent), 0),
+		InviteHandlers:  make([]func(event *InviteEvent), 0),
+		JoinHandlers:    make([]func(resp *JoinRoomResponse), 0),
+		ErrorHandlers:   make([]func(err error), 0),
+		accepted:        make(map[string]bool),
+	}
+}
+
+// OnTyping registers a handler for typing events
+func (s *Syncer) OnTyping(fn func(event *TypingEvent)) {
+	s.TypingHandlers = append(s.TypingHandlers, fn)
+}
+
+// OnReceipt registers a handler for receipt events
+func (s *Syncer) OnReceipt(fn func(event *ReceiptEvent)) {
+	s.ReceiptHandlers = append(s.ReceiptHandlers, fn)
+}
+
+// OnInvite registers a handler for invites
+func (s *Syncer) OnInvite(fn func(event *InviteEvent)) {
+	s.InviteHandlers = append(s.InviteHandlers, fn)
+}
+
+// OnJoin registers a handler for rooms joined by AutoAcceptInvites
+func (s *Syncer) OnJoin(fn func(resp *JoinRoomResponse)) {
+	s.JoinHandlers = append(s.JoinHandlers, fn)
+}
+
+// OnError registers a handler for errors Run hits while dispatching a sync
+// response
+func (s *Syncer) OnError(fn func(err error)) {
+	s.ErrorHandlers = append(s.ErrorHandlers, fn)
+}
+
+// SyncOnce performs one long-poll sync from Since, advances Since to its
+// next_batch and dispatches the response. If dispatching fails the response
+// is returned together with the error; Since has advanced regardless, so the
+// same batch is not delivered again.
+func (s *Syncer) SyncOnce(ctx context.Context) (*SyncResponse, error) {
+	timeout := s.SyncTimeout
+	if timeout <= 0 {
+		timeout = DefaultSyncTimeout
+	}
+
+	resp, err := s.client.Sync.Sync(ctx, &SyncRequest{
+		Since:   s.Since,
+		Timeout: timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Since = resp.NextBatch
+	if err := s.ProcessResponse(ctx, resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// maxSyncRetryDelay caps the backoff between failed syncs in Run
+const maxSyncRetryDelay = 30 * time.Second
+
+// Run syncs until ctx ends or the access token is rejected. Transient
+// failures are retried with backoff from the stored Since token, so a
+// reconnect resumes incremental sync; only when the server rejects the
+// token itself (M_UNKNOWN) does Run fall back to a full initial sync.
+func (s *Syncer) Run(ctx context.Context) error {
+	delay := s.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	failures := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		resp, err := s.SyncOnce(ctx)
+		if err == nil || resp != nil {
+			// A failed dispatch is reported but does not hold up the stream
+			if err != nil {
+				for _, fn := range s.ErrorHandlers {
+					fn(err)
+				}
+			}
+			failures = 0
+			continue
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && (apiErr.Code == 401 || apiErr.Errcode == ErrcodeUnknownToken) {
+			return err
+		}
+		if s.Since != "" && Errcode(err) == ErrcodeUnknown {
+			// The since token is no longer valid: start over with a full sync
+			s.Since = ""
+			continue
+		}
+
+		// failures stops growing once the cap is reached, so the shift
+		// cannot overflow however long the server stays down
+		backoff := delay << uint(failures)
+		if backoff >= maxSyncRetryDelay || backoff <= 0 {
+			backoff = maxSyncRetryDelay
+		} else {
+			failures++
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// ProcessResponse dispatches the events of a sync response to the handlers.
+// An event that fails to decode does not stop the others from being
+// dispatched; all errors are returned joined.
+func (s *Syncer) ProcessResponse(ctx context.Context, resp *SyncResponse) error {
+	var errs []error
+	for roomID, room := range resp.Rooms.Join {
+		for i := range room.Timeline.Events {
+			s.notifyWatchers(roomID, &room.Timeline.Events[i])
+		}
+		for _, event := range room.Ephemeral.Events {
+			if err := s.handleEphemeral(roomID, &event); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(resp.Rooms.Leave) > 0 {
+		s.acceptedMu.Lock()
+		for roomID := range resp.Rooms.Leave {
+			delete(s.accepted, roomID)
+		}
+		s.acceptedMu.Unlock()
+	}
+	for roomID, room := range resp.Rooms.Invite {
+		invite := newInviteEvent(roomID, &room)
+		for _, fn := range s.InviteHandlers {
+			fn(invite)
+		}
+		if err := s.acceptInvite(ctx, invite); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// watchTimeline registers fn to observe every timeline event of later sync
+// responses and returns a function that removes it again
+func (s *Syncer) watchTimeline(fn func(roomID string, event *MessageEvent)) func() {
+	s.watchersMu.Lock()
+	defer s.watchersMu.Unlock()
+
+	if s.watchers == nil {
+		s.watchers = make(map[int]func(roomID string, event *MessageEvent))
+	}
+	id := s.nextWatcher
+	s.nextWatcher++
+	s.watchers[id] = fn
+
+	return func() {
+		s.watchersMu.Lock()
+		delete(s.watchers, id)
+		s.watchersMu.Unlock()
+	}
+}
+
+// notifyWatchers passes a timeline event to the registered watchers
+func (s *Syncer) notifyWatchers(roomID string, event *MessageEvent) {
+	s.watchersMu.Lock()
+	defer s.watchersMu.Unlock()
+
+	for _, fn := range s.watchers {
+		fn(roomID, event)
+	}
+}
+
+// acceptInvite joins the invited room when AutoAcceptInvites allows it
+func (s *Syncer) acceptInvite(ctx context.Context, invite *InviteEvent) error {
+	if s.AutoAcceptInvites == nil || !s.AutoAcceptInvites(*invite) {
+		return nil
+	}
+
+	s.acceptedMu.Lock()
+	if s.accepted[invite.RoomID] {
+		s.acceptedMu.Unlock()
+		return nil
+	}
+	s.accepted[invite.RoomID] = true
+	s.acceptedMu.Unlock()
+
+	resp, err := s.client.Room.JoinRoom(ctx, invite.RoomID, nil)
+	if err != nil {
+		// Allow the next sync to retry the join
+		s.acceptedMu.Lock()
+		delete(s.accepted, invite.RoomID)
+		s.acceptedMu.Unlock()
+		return fmt.Errorf("failed to accept invite to %s: %w", invite.RoomID, err)
+	}
+
+	for _, fn := range s.JoinHandlers {
+		fn(resp)
+	}
+	return nil
+}
+
+// handleEphemeral decodes a single ephemeral event and dispatches it
+func (s *Syncer) handleEphemeral(roomID string, event *MessageEvent) error {
+	switch event.Type {
+	case EventTypeTyping:
+		typing := &TypingEvent{}
+		if err := decodeContent(event.Content, typing); err != nil {
+			return fmt.Errorf("failed to decode typing event: %w", err)
+		}
+		typing.RoomID = roomID
+		for _, fn := range s.TypingHandlers {
+			fn(typing)
+		}
+	case EventTypeReceipt:
+		receipt, err := parseReceiptContent(roomID, event.Content)
+		if err != nil {
+			return fmt.Errorf("failed to decode receipt event: %w", err)
+		}
+		for _, fn := range s.ReceiptHandlers {
+			fn(receipt)
+		}
+	}
+	return nil
+}
+
+// parseReceiptContent flattens the event -> type -> user receipt map
+func parseReceiptContent(roomID string, content map[string]interface{}) (*ReceiptEvent, error) {
+	var raw map[string]map[string]map[string]Receipt
+	if err := decodeContent(content, &raw); err != nil {
+		return nil, err
+	}
+
+	event := &ReceiptEvent{RoomID: roomID}
+	for eventID, types := range raw {
+		for receiptType, users := range types {
+			for userID, receipt := range users {
+				receipt.EventID = eventID
+				receipt.Type = receiptType
+				receipt.UserID = userID
+				event.Receipts = append(event.Receipts, receipt)
+			}
+		}
+	}
+	return event, nil
+}
+
+// decodeContent converts a generic event content map into a typed struct
+func decodeContent(content map[string]interface{}, out interface{}) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,612 @@
+package taibai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSyncEphemeralBody = `{
+	"next_batch": "s72595_4483_1934",
+	"rooms": {
+		"join": {
+			"!room:localhost": {
+				"timeline": {"events": []},
+				"ephemeral": {
+					"events": [
+						{
+							"type": "m.typing",
+							"content": {"user_ids": ["@alice:localhost", "@bob:localhost"]}
+						},
+						{
+							"type": "m.receipt",
+							"content": {
+								"$event-1": {
+									"m.read": {
+										"@alice:localhost": {"ts": 1436451550453, "thread_id": "main"}
+									}
+								}
+							}
+						}
+					]
+				}
+			}
+		}
+	}
+}`
+
+func TestSyncResponseEphemeral(t *testing.T) {
+	var resp SyncResponse
+	if err := json.Unmarshal([]byte(testSyncEphemeralBody), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal SyncResponse: %v", err)
+	}
+
+	if resp.NextBatch != "s72595_4483_1934" {
+		t.Errorf("Expected next_batch 's72595_4483_1934', got '%s'", resp.NextBatch)
+	}
+
+	room, ok := resp.Rooms.Join["!room:localhost"]
+	if !ok {
+		t.Fatal("Expected joined room '!room:localhost'")
+	}
+
+	if len(room.Ephemeral.Events) != 2 {
+		t.Errorf("Expected 2 ephemeral events, got %d", len(room.Ephemeral.Events))
+	}
+}
+
+func TestSyncResponseUnreadNotifications(t *testing.T) {
+	body := `{
+		"next_batch": "s1",
+		"rooms": {
+			"join": {
+				"!room:localhost": {
+					"unread_notifications": {"highlight_count": 2, "notification_count": 7}
+				},
+				"!quiet:localhost": {}
+			}
+		}
+	}`
+
+	var resp SyncResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal SyncResponse: %v", err)
+	}
+
+	counts := resp.Rooms.Join["!room:localhost"].UnreadNotifications
+	if counts.HighlightCount != 2 {
+		t.Errorf("Expected highlight count 2, got %d", counts.HighlightCount)
+	}
+	if counts.NotificationCount != 7 {
+		t.Errorf("Expected notification count 7, got %d", counts.NotificationCount)
+	}
+
+	quiet := resp.Rooms.Join["!quiet:localhost"].UnreadNotifications
+	if quiet.HighlightCount != 0 || quiet.NotificationCount != 0 {
+		t.Errorf("Expected zero counts, got %+v", quiet)
+	}
+}
+
+func TestSyncerEphemeralEvents(t *testing.T) {
+	var resp SyncResponse
+	if err := json.Unmarshal([]byte(testSyncEphemeralBody), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal SyncResponse: %v", err)
+	}
+
+	syncer := NewSyncer(nil)
+
+	var typing *TypingEvent
+	syncer.OnTyping(func(event *TypingEvent) {
+		typing = event
+	})
+
+	var receipt *ReceiptEvent
+	syncer.OnReceipt(func(event *ReceiptEvent) {
+		receipt = event
+	})
+
+	if err := syncer.ProcessResponse(context.Background(), &resp); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if typing == nil {
+		t.Fatal("Expected typing handler to be called")
+	}
+	if typing.RoomID != "!room:localhost" {
+		t.Errorf("Expected room_id '!room:localhost', got '%s'", typing.RoomID)
+	}
+	if len(typing.UserIDs) != 2 || typing.UserIDs[0] != "@alice:localhost" {
+		t.Errorf("Expected typing users [@alice:localhost @bob:localhost], got %v", typing.UserIDs)
+	}
+
+	if receipt == nil {
+		t.Fatal("Expected receipt handler to be called")
+	}
+	if len(receipt.Receipts) != 1 {
+		t.Fatalf("Expected 1 receipt, got %d", len(receipt.Receipts))
+	}
+
+	r := receipt.Receipts[0]
+	if r.EventID != "$event-1" || r.Type != "m.read" || r.UserID != "@alice:localhost" {
+		t.Errorf("Unexpected receipt: %+v", r)
+	}
+	if r.Timestamp != 1436451550453 {
+		t.Errorf("Expected ts 1436451550453, got %d", r.Timestamp)
+	}
+	if r.ThreadID != "main" {
+		t.Errorf("Expected thread_id 'main', got '%s'", r.ThreadID)
+	}
+}
+
+const testSyncInviteBody = `{
+	"next_batch": "s72596",
+	"rooms": {
+		"invite": {
+			"!invited:localhost": {
+				"invite_state": {
+					"events": [
+						{
+							"type": "m.room.name",
+							"state_key": "",
+							"sender": "@alice:localhost",
+							"content": {"name": "Ops Room"}
+						},
+						{
+							"type": "m.room.join_rules",
+							"state_key": "",
+							"sender": "@alice:localhost",
+							"content": {"join_rule": "invite"}
+						},
+						{
+							"type": "m.room.member",
+							"state_key": "@bot:localhost",
+							"sender": "@alice:localhost",
+							"content": {"membership": "invite"}
+						}
+					]
+				}
+			}
+		}
+	}
+}`
+
+func TestSyncResponseInviteState(t *testing.T) {
+	var resp SyncResponse
+	if err := json.Unmarshal([]byte(testSyncInviteBody), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal SyncResponse: %v", err)
+	}
+
+	room, ok := resp.Rooms.Invite["!invited:localhost"]
+	if !ok {
+		t.Fatal("Expected invited room '!invited:localhost'")
+	}
+
+	if len(room.InviteState.Events) != 3 {
+		t.Fatalf("Expected 3 stripped state events, got %d", len(room.InviteState.Events))
+	}
+
+	if room.InviteState.Events[2].StateKey != "@bot:localhost" {
+		t.Errorf("Expected state_key '@bot:localhost', got '%s'", room.InviteState.Events[2].StateKey)
+	}
+}
+
+func TestSyncerInviteEvent(t *testing.T) {
+	var resp SyncResponse
+	if err := json.Unmarshal([]byte(testSyncInviteBody), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal SyncResponse: %v", err)
+	}
+
+	syncer := NewSyncer(nil)
+
+	var invite *InviteEvent
+	syncer.OnInvite(func(event *InviteEvent) {
+		invite = event
+	})
+
+	if err := syncer.ProcessResponse(context.Background(), &resp); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if invite == nil {
+		t.Fatal("Expected invite handler to be called")
+	}
+	if invite.RoomID != "!invited:localhost" {
+		t.Errorf("Expected room_id '!invited:localhost', got '%s'", invite.RoomID)
+	}
+	if invite.Inviter != "@alice:localhost" {
+		t.Errorf("Expected inviter '@alice:localhost', got '%s'", invite.Inviter)
+	}
+	if invite.RoomName != "Ops Room" {
+		t.Errorf("Expected room name 'Ops Room', got '%s'", invite.RoomName)
+	}
+	if invite.JoinRule != "invite" {
+		t.Errorf("Expected join rule 'invite', got '%s'", invite.JoinRule)
+	}
+}
+
+func TestSyncerAutoAcceptInvites(t *testing.T) {
+	var joins []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		joins = append(joins, req.URL.Path)
+		return newMockResponse(200, map[string]string{"room_id": "!invited:localhost"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	syncer := NewSyncer(client)
+	syncer.AutoAcceptInvites = func(invite InviteEvent) bool {
+		return invite.Inviter == "@alice:localhost"
+	}
+
+	var joined *JoinRoomResponse
+	syncer.OnJoin(func(resp *JoinRoomResponse) {
+		joined = resp
+	})
+
+	// Deliver the same invite twice; only one join must be issued
+	for i := 0; i < 2; i++ {
+		var resp SyncResponse
+		if err := json.Unmarshal([]byte(testSyncInviteBody), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal SyncResponse: %v", err)
+		}
+		if err := syncer.ProcessResponse(context.Background(), &resp); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if len(joins) != 1 {
+		t.Fatalf("Expected 1 join request, got %d", len(joins))
+	}
+	if !strings.HasSuffix(joins[0], "/join/!invited:localhost") {
+		t.Errorf("Unexpected join path '%s'", joins[0])
+	}
+	if joined == nil || joined.RoomID != "!invited:localhost" {
+		t.Errorf("Expected join handler to receive '!invited:localhost', got %+v", joined)
+	}
+}
+
+func TestSyncerAutoAcceptReinviteAndErrors(t *testing.T) {
+	var joins []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		joins = append(joins, req.URL.Path)
+		if strings.HasSuffix(req.URL.Path, "/join/!a:localhost") {
+			return newMockResponse(403, map[string]string{"errcode": "M_FORBIDDEN", "error": "banned"}), nil
+		}
+		return newMockResponse(200, map[string]string{"room_id": "!b:localhost"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	syncer := NewSyncer(client)
+	syncer.AutoAcceptInvites = func(invite InviteEvent) bool { return true }
+	invite := func(roomIDs ...string) *SyncResponse {
+		resp := &SyncResponse{}
+		resp.Rooms.Invite = make(map[string]SyncInvitedRoom)
+		for _, roomID := range roomIDs {
+			resp.Rooms.Invite[roomID] = SyncInvitedRoom{}
+		}
+		return resp
+	}
+
+	// One failing join must not keep the other invite from being accepted
+	err := syncer.ProcessResponse(context.Background(), invite("!a:localhost", "!b:localhost"))
+	if Errcode(err) != "M_FORBIDDEN" {
+		t.Errorf("Expected the failed join to be returned, got %v", err)
+	}
+	if len(joins) != 2 {
+		t.Fatalf("Expected both invites to be attempted, got %v", joins)
+	}
+
+	// After leaving !b, a new invite to it is accepted again
+	left := &SyncResponse{}
+	left.Rooms.Leave = map[string]SyncLeftRoom{"!b:localhost": {}}
+	if err := syncer.ProcessResponse(context.Background(), left); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	joins = nil
+	syncer.ProcessResponse(context.Background(), invite("!b:localhost"))
+	if len(joins) != 1 || !strings.HasSuffix(joins[0], "/join/!b:localhost") {
+		t.Errorf("Expected the re-invite to be accepted, got %v", joins)
+	}
+}
+
+func TestSyncerAutoAcceptInvitesRejected(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("Unexpected request to %s", req.URL.Path)
+		return newMockResponse(200, nil), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Room = &RoomAPI{client: client}
+
+	syncer := NewSyncer(client)
+	syncer.AutoAcceptInvites = func(invite InviteEvent) bool {
+		return invite.Inviter == "@trusted:localhost"
+	}
+	syncer.OnJoin(func(resp *JoinRoomResponse) {
+		t.Error("Expected join handler not to be called")
+	})
+
+	var resp SyncResponse
+	if err := json.Unmarshal([]byte(testSyncInviteBody), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal SyncResponse: %v", err)
+	}
+	if err := syncer.ProcessResponse(context.Background(), &resp); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestSyncerSyncOnceTimeout(t *testing.T) {
+	var timeouts, sinces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeouts = append(timeouts, r.URL.Query().Get("timeout"))
+		sinces = append(sinces, r.URL.Query().Get("since"))
+
+		// Hold the poll longer than the client's global timeout
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte(`{"next_batch": "batch-` + strconv.Itoa(len(sinces)) + `"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&Config{
+		ServerAddress: server.URL,
+		Token:         "test-token",
+		Timeout:       100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	syncer := NewSyncer(client)
+	syncer.SyncTimeout = 200 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		if _, err := syncer.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("Expected sync not to be cut short by the client timeout, got %v", err)
+		}
+	}
+
+	if timeouts[0] != "200" {
+		t.Errorf("Expected timeout '200', got '%s'", timeouts[0])
+	}
+	if sinces[0] != "" || sinces[1] != "batch-1" {
+		t.Errorf("Expected since to follow next_batch, got %v", sinces)
+	}
+	if syncer.Since != "batch-2" {
+		t.Errorf("Expected Since 'batch-2', got '%s'", syncer.Since)
+	}
+}
+
+func TestSyncAPISync(t *testing.T) {
+	var query map[string]string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/_matrix/client/r0/sync" {
+			t.Errorf("Unexpected path '%s'", req.URL.Path)
+		}
+		query = map[string]string{}
+		for key := range req.URL.Query() {
+			query[key] = req.URL.Query().Get(key)
+		}
+		return newMockResponse(200, map[string]interface{}{
+			"next_batch": "batch-2",
+			"rooms": map[string]interface{}{
+				"join":   map[string]interface{}{"!joined:localhost": map[string]interface{}{}},
+				"invite": map[string]interface{}{"!invited:localhost": map[string]interface{}{}},
+				"leave":  map[string]interface{}{"!left:localhost": map[string]interface{}{}},
+			},
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Sync = &SyncAPI{client: client}
+
+	resp, err := client.Sync.Sync(context.Background(), &SyncRequest{
+		Since:   "batch-1",
+		Timeout: 30 * time.Second,
+		Filter:  "filter-id",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if query["since"] != "batch-1" || query["timeout"] != "30000" || query["filter"] != "filter-id" {
+		t.Errorf("Unexpected sync query %v", query)
+	}
+	if resp.NextBatch != "batch-2" {
+		t.Errorf("Expected next_batch 'batch-2', got '%s'", resp.NextBatch)
+	}
+	if len(resp.Rooms.Join) != 1 || len(resp.Rooms.Invite) != 1 || len(resp.Rooms.Leave) != 1 {
+		t.Errorf("Expected one joined, invited and left room, got %+v", resp.Rooms)
+	}
+}
+
+func TestSyncAPISyncLoop(t *testing.T) {
+	var sinces []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		sinces = append(sinces, req.URL.Query().Get("since"))
+		return newMockResponse(200, map[string]string{
+			"next_batch": "batch-" + strconv.Itoa(len(sinces)),
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Sync = &SyncAPI{client: client}
+
+	stop := errors.New("stop")
+	var batches []string
+	err := client.Sync.SyncLoop(context.Background(), func(resp *SyncResponse) error {
+		batches = append(batches, resp.NextBatch)
+		if len(batches) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("Expected loop to stop with the handler error, got %v", err)
+	}
+
+	if len(sinces) != 2 || sinces[0] != "" || sinces[1] != "batch-1" {
+		t.Errorf("Expected since tokens ['' 'batch-1'], got %v", sinces)
+	}
+	if batches[1] != "batch-2" {
+		t.Errorf("Expected second next_batch 'batch-2', got '%s'", batches[1])
+	}
+}
+
+func TestSyncerRunResumesAndResyncs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var sinces []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		sinces = append(sinces, req.URL.Query().Get("since"))
+		switch len(sinces) {
+		case 1:
+			return newMockResponse(200, map[string]string{"next_batch": "s1"}), nil
+		case 2:
+			// Transient failure: the next attempt must reuse s1
+			return newMockResponse(502, map[string]string{"error": "bad gateway"}), nil
+		case 3:
+			return newMockResponse(200, map[string]string{"next_batch": "s2"}), nil
+		case 4:
+			return newMockResponse(400, map[string]string{"errcode": "M_UNKNOWN", "error": "Invalid since token"}), nil
+		default:
+			cancel()
+			return newMockResponse(200, map[string]string{"next_batch": "s3"}), nil
+		}
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Sync = &SyncAPI{client: client}
+
+	syncer := NewSyncer(client)
+	syncer.RetryDelay = time.Millisecond
+
+	if err := syncer.Run(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	expected := []string{"", "s1", "s1", "s2", ""}
+	if strings.Join(sinces, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected since tokens %q, got %q", expected, sinces)
+	}
+	if syncer.Since != "s3" {
+		t.Errorf("Expected Since 's3', got '%s'", syncer.Since)
+	}
+}
+
+func TestSyncerRunSkipsFailedAutoJoin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var sinces []string
+	joins := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.Contains(req.URL.Path, "/join/") {
+			joins++
+			return newMockResponse(403, map[string]string{"errcode": "M_FORBIDDEN", "error": "You are not invited"}), nil
+		}
+		sinces = append(sinces, req.URL.Query().Get("since"))
+		if len(sinces) == 1 {
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(testSyncInviteBody)),
+				Header:     make(http.Header),
+			}, nil
+		}
+		cancel()
+		return newMockResponse(200, map[string]string{"next_batch": "s2"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Sync = &SyncAPI{client: client}
+	client.Room = &RoomAPI{client: client}
+
+	syncer := NewSyncer(client)
+	syncer.RetryDelay = time.Millisecond
+	syncer.AutoAcceptInvites = func(invite InviteEvent) bool { return true }
+	invites := 0
+	syncer.OnInvite(func(event *InviteEvent) { invites++ })
+	var reported []error
+	syncer.OnError(func(err error) { reported = append(reported, err) })
+
+	if err := syncer.Run(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if strings.Join(sinces, ",") != ",s72596" {
+		t.Errorf("Expected the failed batch to be skipped, got since tokens %q", sinces)
+	}
+	if joins != 1 || invites != 1 {
+		t.Errorf("Expected the invite to be handled once, got %d joins and %d invites", joins, invites)
+	}
+	if len(reported) != 1 || Errcode(reported[0]) != "M_FORBIDDEN" {
+		t.Errorf("Expected the join error to be reported, got %v", reported)
+	}
+}
+
+func TestSyncerRunStopsOnUnknownToken(t *testing.T) {
+	requests := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return newMockResponse(401, map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.Sync = &SyncAPI{client: client}
+
+	syncer := NewSyncer(client)
+	syncer.Since = "s1"
+
+	err := syncer.Run(context.Background())
+	if Errcode(err) != ErrcodeUnknownToken {
+		t.Errorf("Expected M_UNKNOWN_TOKEN error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected Run to stop after 1 request, got %d", requests)
+	}
+	if syncer.Since != "s1" {
+		t.Errorf("Expected Since to be kept, got '%s'", syncer.Since)
+	}
+}
@@ -2,6 +2,8 @@ package taibai
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 )
 
 // ==================== User API ====================
@@ -65,6 +67,173 @@ func (u *UserAPI) ValidateToken(ctx context.Context, req *ValidateTokenRequest)
 	return resp, err
 }
 
+// GetAllRoomAccountData returns the per-room account data of eventType for
+// every joined room, keyed by room ID. It is read from a one-off filtered
+// sync, so it always describes the authenticated user: an error is returned
+// if userID is someone else.
+func (u *UserAPI) GetAllRoomAccountData(ctx context.Context, userID, eventType string) (map[string]json.RawMessage, error) {
+	whoami, err := u.client.Auth.WhoAmI(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the current user: %w", err)
+	}
+	if whoami.UserID != userID {
+		return nil, fmt.Errorf("cannot read room account data of %s: authenticated as %s", userID, whoami.UserID)
+	}
+
+	filter, err := json.Marshal(map[string]interface{}{
+		"account_data": map[string]interface{}{"types": []string{}},
+		"presence":     map[string]interface{}{"types": []string{}},
+		"room": map[string]interface{}{
+			"account_data": map[string]interface{}{"types": []string{eventType}},
+			"ephemeral":    map[string]interface{}{"types": []string{}},
+			"state":        map[string]interface{}{"types": []string{}},
+			"timeline":     map[string]interface{}{"limit": 0},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := u.client.Sync.Sync(ctx, &SyncRequest{Filter: string(filter)})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]json.RawMessage)
+	for roomID, room := range resp.Rooms.Join {
+		for _, event := range room.AccountData.Events {
+			if event.Type == eventType {
+				result[roomID] = event.Content
+			}
+		}
+	}
+	return result, nil
+}
+
+// GetAccountData decodes the global account data of dataType for userID into out.
+func (u *UserAPI) GetAccountData(ctx context.Context, userID, dataType string, out interface{}) error {
+	return u.client.GET(ctx, u.accountDataPath(userID, "", dataType), nil, out)
+}
+
+// SetAccountData stores data as the global account data of dataType for userID.
+func (u *UserAPI) SetAccountData(ctx context.Context, userID, dataType string, data interface{}) error {
+	return u.client.PUT(ctx, u.accountDataPath(userID, "", dataType), data, nil)
+}
+
+// GetRoomAccountData decodes the account data of dataType that userID stored
+// for roomID into out.
+func (u *UserAPI) GetRoomAccountData(ctx context.Context, userID, roomID, dataType string, out interface{}) error {
+	return u.client.GET(ctx, u.accountDataPath(userID, roomID, dataType), nil, out)
+}
+
+// SetRoomAccountData stores data as the account data of dataType for userID
+// in roomID.
+func (u *UserAPI) SetRoomAccountData(ctx context.Context, userID, roomID, dataType string, data interface{}) error {
+	return u.client.PUT(ctx, u.accountDataPath(userID, roomID, dataType), data, nil)
+}
+
+func (u *UserAPI) accountDataPath(userID, roomID, dataType string) string {
+	path := u.client.apiPrefix() + "/user/" + pathEscape(userID)
+	if roomID != "" {
+		path += "/rooms/" + pathEscape(roomID)
+	}
+	return path + "/account_data/" + pathEscape(dataType)
+}
+
+// Profile is a user's public display name and avatar.
+type Profile struct {
+	DisplayName string `json:"displayname,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// GetProfile returns the profile of userID.
+func (u *UserAPI) GetProfile(ctx context.Context, userID string) (*Profile, error) {
+	resp := &Profile{}
+	err := u.client.GET(ctx, u.client.apiPrefix()+"/profile/"+pathEscape(userID), nil, resp)
+	return resp, err
+}
+
+// SetDisplayName changes the display name of userID.
+func (u *UserAPI) SetDisplayName(ctx context.Context, userID, name string) error {
+	path := u.client.apiPrefix() + "/profile/" + pathEscape(userID) + "/displayname"
+	return u.client.PUT(ctx, path, map[string]string{"displayname": name}, nil)
+}
+
+// SetAvatarURL changes the avatar of userID to the given mxc:// URI.
+func (u *UserAPI) SetAvatarURL(ctx context.Context, userID, mxc string) error {
+	path := u.client.apiPrefix() + "/profile/" + pathEscape(userID) + "/avatar_url"
+	return u.client.PUT(ctx, path, map[string]string{"avatar_url": mxc}, nil)
+}
+
+// GetProfileField decodes the profile field of userID into out. Extensible
+// profile fields (MSC4133) may hold any JSON value. An unset field (M_NOT_FOUND)
+// is not an error: out is left untouched. Other 404s, such as M_UNRECOGNIZED
+// from servers without the endpoint, are returned.
+func (u *UserAPI) GetProfileField(ctx context.Context, userID, field string, out interface{}) error {
+	var resp map[string]json.RawMessage
+	err := u.client.GET(ctx, u.profileFieldPath(userID, field), nil, &resp)
+	if err != nil {
+		if Errcode(err) == ErrcodeNotFound {
+			return nil
+		}
+		return err
+	}
+
+	value, ok := resp[field]
+	if !ok || out == nil {
+		return nil
+	}
+	return json.Unmarshal(value, out)
+}
+
+// SetProfileField sets the profile field of userID to value.
+func (u *UserAPI) SetProfileField(ctx context.Context, userID, field string, value interface{}) error {
+	return u.client.PUT(ctx, u.profileFieldPath(userID, field), map[string]interface{}{field: value}, nil)
+}
+
+func (u *UserAPI) profileFieldPath(userID, field string) string {
+	return u.client.apiPrefix() + "/profile/" + pathEscape(userID) + "/" + pathEscape(field)
+}
+
+// Presence states
+const (
+	PresenceOnline      = "online"
+	PresenceOffline     = "offline"
+	PresenceUnavailable = "unavailable"
+)
+
+// PresenceResponse is the presence state of a user.
+type PresenceResponse struct {
+	Presence        string `json:"presence"`
+	LastActiveAgo   int64  `json:"last_active_ago,omitempty"`
+	StatusMsg       string `json:"status_msg,omitempty"`
+	CurrentlyActive bool   `json:"currently_active,omitempty"`
+}
+
+// SetPresence publishes the presence state of userID with an optional status message.
+func (u *UserAPI) SetPresence(ctx context.Context, userID, presence, statusMsg string) error {
+	switch presence {
+	case PresenceOnline, PresenceOffline, PresenceUnavailable:
+	default:
+		return fmt.Errorf("invalid presence %q: must be one of %s, %s, %s",
+			presence, PresenceOnline, PresenceOffline, PresenceUnavailable)
+	}
+
+	body := map[string]string{"presence": presence}
+	if statusMsg != "" {
+		body["status_msg"] = statusMsg
+	}
+	path := u.client.apiPrefix() + "/presence/" + pathEscape(userID) + "/status"
+	return u.client.PUT(ctx, path, body, nil)
+}
+
+// GetPresence returns the presence state of userID.
+func (u *UserAPI) GetPresence(ctx context.Context, userID string) (*PresenceResponse, error) {
+	resp := &PresenceResponse{}
+	err := u.client.GET(ctx, u.client.apiPrefix()+"/presence/"+pathEscape(userID)+"/status", nil, resp)
+	return resp, err
+}
+
 // ==================== Approval API ====================
 
 type ApprovalAPI struct {
@@ -0,0 +1,392 @@
+package taibai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetAllRoomAccountData(t *testing.T) {
+	var filter map[string]interface{}
+	syncs := 0
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/account/whoami") {
+			return newMockResponse(200, map[string]string{"user_id": "@bot:localhost"}), nil
+		}
+		syncs++
+		json.Unmarshal([]byte(req.URL.Query().Get("filter")), &filter)
+		return newMockResponse(200, map[string]interface{}{
+			"next_batch": "s1",
+			"rooms": map[string]interface{}{
+				"join": map[string]interface{}{
+					"!a:localhost": map[string]interface{}{
+						"account_data": map[string]interface{}{"events": []interface{}{
+							map[string]interface{}{"type": "com.example.cursor", "content": map[string]string{"event_id": "$a"}},
+						}},
+					},
+					"!b:localhost": map[string]interface{}{
+						"account_data": map[string]interface{}{"events": []interface{}{
+							map[string]interface{}{"type": "com.example.cursor", "content": map[string]string{"event_id": "$b"}},
+							map[string]interface{}{"type": "m.tag", "content": map[string]interface{}{}},
+						}},
+					},
+					"!c:localhost": map[string]interface{}{
+						"account_data": map[string]interface{}{"events": []interface{}{
+							map[string]interface{}{"type": "com.example.cursor", "content": map[string]string{"event_id": "$c"}},
+						}},
+					},
+					"!d:localhost": map[string]interface{}{},
+				},
+			},
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.User = &UserAPI{client: client}
+	client.Sync = &SyncAPI{client: client}
+	client.Auth = &AuthAPI{client: client}
+
+	// Another user's account data cannot be read through our sync
+	if _, err := client.User.GetAllRoomAccountData(context.Background(), "@other:localhost", "com.example.cursor"); err == nil {
+		t.Error("Expected an error for another user's ID")
+	}
+	if syncs != 0 {
+		t.Errorf("Expected no sync for a mismatched user, got %d", syncs)
+	}
+
+	data, err := client.User.GetAllRoomAccountData(context.Background(), "@bot:localhost", "com.example.cursor")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	room, _ := filter["room"].(map[string]interface{})
+	accountData, _ := room["account_data"].(map[string]interface{})
+	types, _ := accountData["types"].([]interface{})
+	if len(types) != 1 || types[0] != "com.example.cursor" {
+		t.Errorf("Expected sync filter on com.example.cursor, got %v", filter)
+	}
+
+	if len(data) != 3 {
+		t.Fatalf("Expected cursors for 3 rooms, got %d", len(data))
+	}
+	for _, roomID := range []string{"!a", "!b", "!c"} {
+		var cursor struct {
+			EventID string `json:"event_id"`
+		}
+		if err := json.Unmarshal(data[roomID+":localhost"], &cursor); err != nil {
+			t.Fatalf("Failed to decode cursor of %s: %v", roomID, err)
+		}
+		if cursor.EventID != "$"+roomID[1:] {
+			t.Errorf("Expected cursor '$%s', got '%s'", roomID[1:], cursor.EventID)
+		}
+	}
+}
+
+func TestGetProfile(t *testing.T) {
+	var path string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path = req.URL.EscapedPath()
+		return newMockResponse(200, map[string]string{
+			"displayname": "Bot",
+			"avatar_url":  "mxc://localhost/avatar",
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.User = &UserAPI{client: client}
+
+	profile, err := client.User.GetProfile(context.Background(), "@bot:localhost")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if path != "/_matrix/client/r0/profile/@bot:localhost" {
+		t.Errorf("Expected profile path, got '%s'", path)
+	}
+	if profile.DisplayName != "Bot" {
+		t.Errorf("Expected display name 'Bot', got '%s'", profile.DisplayName)
+	}
+	if profile.AvatarURL != "mxc://localhost/avatar" {
+		t.Errorf("Expected avatar 'mxc://localhost/avatar', got '%s'", profile.AvatarURL)
+	}
+}
+
+func TestSetProfileFields(t *testing.T) {
+	var method, path string
+	var body map[string]string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		method = req.Method
+		path = req.URL.EscapedPath()
+		body = nil
+		json.NewDecoder(req.Body).Decode(&body)
+		return newMockResponse(200, map[string]interface{}{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.User = &UserAPI{client: client}
+
+	if err := client.User.SetDisplayName(context.Background(), "@bot:localhost", "Bot"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if method != "PUT" {
+		t.Errorf("Expected method PUT, got %s", method)
+	}
+	if path != "/_matrix/client/r0/profile/@bot:localhost/displayname" {
+		t.Errorf("Expected displayname path, got '%s'", path)
+	}
+	if body["displayname"] != "Bot" {
+		t.Errorf("Expected displayname 'Bot', got %v", body)
+	}
+
+	if err := client.User.SetAvatarURL(context.Background(), "@bot:localhost", "mxc://localhost/avatar"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if path != "/_matrix/client/r0/profile/@bot:localhost/avatar_url" {
+		t.Errorf("Expected avatar_url path, got '%s'", path)
+	}
+	if body["avatar_url"] != "mxc://localhost/avatar" {
+		t.Errorf("Expected avatar_url 'mxc://localhost/avatar', got %v", body)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	var method, path string
+	var body map[string]string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		method = req.Method
+		path = req.URL.EscapedPath()
+		if req.Method == "PUT" {
+			json.NewDecoder(req.Body).Decode(&body)
+			return newMockResponse(200, map[string]interface{}{}), nil
+		}
+		return newMockResponse(200, map[string]interface{}{
+			"presence":         "online",
+			"status_msg":       "Ready",
+			"currently_active": true,
+		}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.User = &UserAPI{client: client}
+
+	if err := client.User.SetPresence(context.Background(), "@bot:localhost", PresenceOnline, "Ready"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if method != "PUT" {
+		t.Errorf("Expected method PUT, got %s", method)
+	}
+	if path != "/_matrix/client/r0/presence/@bot:localhost/status" {
+		t.Errorf("Expected presence path, got '%s'", path)
+	}
+	if body["presence"] != "online" || body["status_msg"] != "Ready" {
+		t.Errorf("Expected presence 'online' with status 'Ready', got %v", body)
+	}
+
+	presence, err := client.User.GetPresence(context.Background(), "@bot:localhost")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if presence.Presence != PresenceOnline {
+		t.Errorf("Expected presence 'online', got '%s'", presence.Presence)
+	}
+	if presence.StatusMsg != "Ready" {
+		t.Errorf("Expected status 'Ready', got '%s'", presence.StatusMsg)
+	}
+	if !presence.CurrentlyActive {
+		t.Error("Expected currently_active to be true")
+	}
+}
+
+func TestSetPresenceInvalid(t *testing.T) {
+	called := false
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		called = true
+		return newMockResponse(200, map[string]interface{}{}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.User = &UserAPI{client: client}
+
+	if err := client.User.SetPresence(context.Background(), "@bot:localhost", "busy", ""); err == nil {
+		t.Error("Expected error for invalid presence")
+	}
+	if called {
+		t.Error("Expected no request for invalid presence")
+	}
+}
+
+func TestAccountData(t *testing.T) {
+	type settings struct {
+		Language string   `json:"language"`
+		Rooms    []string `json:"rooms"`
+	}
+
+	stored := make(map[string][]byte)
+	var paths []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path := req.URL.EscapedPath()
+		paths = append(paths, req.Method+" "+path)
+		if req.Method == "PUT" {
+			data, _ := io.ReadAll(req.Body)
+			stored[path] = data
+			return newMockResponse(200, map[string]interface{}{}), nil
+		}
+		data, ok := stored[path]
+		if !ok {
+			return newMockResponse(404, map[string]string{"errcode": "M_NOT_FOUND"}), nil
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader(data)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.User = &UserAPI{client: client}
+	ctx := context.Background()
+
+	want := settings{Language: "zh", Rooms: []string{"!a:localhost"}}
+	if err := client.User.SetAccountData(ctx, "@bot:localhost", "com.example.settings", want); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var got settings
+	if err := client.User.GetAccountData(ctx, "@bot:localhost", "com.example.settings", &got); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got.Language != "zh" || len(got.Rooms) != 1 || got.Rooms[0] != "!a:localhost" {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	if err := client.User.SetRoomAccountData(ctx, "@bot:localhost", "!a:localhost", "com.example.settings", settings{Language: "en"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var room settings
+	if err := client.User.GetRoomAccountData(ctx, "@bot:localhost", "!a:localhost", "com.example.settings", &room); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if room.Language != "en" {
+		t.Errorf("Expected room language 'en', got '%s'", room.Language)
+	}
+
+	expected := []string{
+		"PUT /_matrix/client/r0/user/@bot:localhost/account_data/com.example.settings",
+		"GET /_matrix/client/r0/user/@bot:localhost/account_data/com.example.settings",
+		"PUT /_matrix/client/r0/user/@bot:localhost/rooms/%21a:localhost/account_data/com.example.settings",
+		"GET /_matrix/client/r0/user/@bot:localhost/rooms/%21a:localhost/account_data/com.example.settings",
+	}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %d requests, got %v", len(expected), paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("Expected '%s', got '%s'", expected[i], paths[i])
+		}
+	}
+}
+
+func TestProfileField(t *testing.T) {
+	type pronouns struct {
+		Summary  string `json:"summary"`
+		Language string `json:"language"`
+	}
+
+	stored := make(map[string][]byte)
+	var paths []string
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		path := req.URL.EscapedPath()
+		paths = append(paths, req.Method+" "+path)
+		if req.Method == "PUT" {
+			data, _ := io.ReadAll(req.Body)
+			stored[path] = data
+			return newMockResponse(200, map[string]interface{}{}), nil
+		}
+		data, ok := stored[path]
+		if !ok {
+			return newMockResponse(404, map[string]string{"errcode": "M_NOT_FOUND"}), nil
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader(data)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.User = &UserAPI{client: client}
+	ctx := context.Background()
+
+	// Unset field
+	var got pronouns
+	if err := client.User.GetProfileField(ctx, "@bot:localhost", "m.pronouns", &got); err != nil {
+		t.Fatalf("Expected no error for an unset field, got %v", err)
+	}
+	if got != (pronouns{}) {
+		t.Errorf("Expected unset field to leave out untouched, got %+v", got)
+	}
+
+	want := pronouns{Summary: "it/its", Language: "en"}
+	if err := client.User.SetProfileField(ctx, "@bot:localhost", "m.pronouns", want); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := client.User.GetProfileField(ctx, "@bot:localhost", "m.pronouns", &got); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	if paths[1] != "PUT /_matrix/client/r0/profile/@bot:localhost/m.pronouns" {
+		t.Errorf("Expected profile field path, got '%s'", paths[1])
+	}
+}
+
+func TestGetProfileFieldUnsupported(t *testing.T) {
+	mock := MockHTTPFunc(func(req *http.Request) (*http.Response, error) {
+		return newMockResponse(404, map[string]string{"errcode": "M_UNRECOGNIZED", "error": "Unrecognized request"}), nil
+	})
+
+	client := &Client{
+		httpClient: mock,
+		baseURL:    "http://localhost:8008",
+		token:      "test-token",
+	}
+	client.User = &UserAPI{client: client}
+
+	var got string
+	err := client.User.GetProfileField(context.Background(), "@bot:localhost", "m.pronouns", &got)
+	if Errcode(err) != "M_UNRECOGNIZED" {
+		t.Errorf("Expected M_UNRECOGNIZED to be returned, got %v", err)
+	}
+}
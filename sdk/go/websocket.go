@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,9 +17,16 @@ import (
 type WebSocketConfig struct {
 	URL            string        // WebSocket 服务器地址
 	Token          string        // 认证 Token
+	TokenProvider  func() string // 动态获取 Token (可选, 如 Client.GetToken), 每次连接和重连时调用, 优先于 Token
 	HeartbeatInterval time.Duration // 心跳间隔 (默认 30 秒)
 	ReconnectDelay   time.Duration // 重连延迟 (默认 5 秒)
+	ReconnectMaxDelay time.Duration // 指数退避的最大重连延迟 (默认 60 秒)
+	ReconnectBackoffFactor float64 // 每次重连失败后延迟的增长倍数 (默认 2, 设为 1 表示固定延迟)
 	MaxReconnectAttempts int       // 最大重连次数 (默认 0 表示无限)
+	FlushTimeout   time.Duration // 断开前等待发送队列清空的最长时间 (默认 0 表示不等待)
+	WriteBufferSize int          // 发送队列长度 (默认 100)
+	WriteTimeout   time.Duration // 订阅、心跳等控制帧在发送队列已满时的最长等待时间 (默认 5 秒)
+	AutoAck        bool          // 收到带序列号的消息后自动回复 ack 帧 (服务端要求确认时开启)
 }
 
 // WebSocketClient WebSocket 客户端
@@ -26,7 +35,7 @@ type WebSocketClient struct {
 	conn         *websocket.Conn
 	isConnected bool
 	isReconnecting bool
-_mu           sync.RWMutex
+	mu           sync.RWMutex // 保护 conn, isConnected, isReconnecting, closed
 	ctx          context.Context
 	cancel       context.CancelFunc
 
@@ -35,6 +44,8 @@ _mu           sync.RWMutex
 	OnDisconnect    func(error)  // 断线回调
 	OnMessage       func(msg *WSMessage) // 消息接收回调
 	OnError         func(error)  // 错误回调
+	OnServerError   func(*WSError) // 服务端错误帧回调
+	OnGap           func(expected, got int64) // 序列号跳跃回调, 用于发现丢失的消息
 
 	// 订阅管理
 	subscriptions map[string]bool
@@ -44,6 +55,29 @@ _mu           sync.RWMutex
 	readChan  chan *WSMessage
 	writeChan chan []byte
 	closeChan chan struct{}
+	pending   int64 // 已入队但尚未写出的消息数
+
+	// 首次连接成功信号
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// 已调用 Disconnect, 不再连接或自动重连 (受 mu 保护)
+	closed bool
+
+	// 当前重连退避基准, 0 表示下次从 ReconnectDelay 开始 (受 mu 保护)
+	backoff time.Duration
+
+	// readLoop 是否在运行 (受 mu 保护); 关闭后由最后一个读取方关闭 readChan
+	reading       bool
+	readCloseOnce sync.Once
+
+	// 当前连接上最近收到的服务端消息序列号, 每次连接成功时清零
+	lastSeq int64
+
+	// 关联请求: 按请求 ID 等待响应
+	nextSeq   int64
+	waiters   map[int64]chan *WSMessage
+	waitersMu sync.Mutex
 }
 
 // WSMessage WebSocket 消息结构
@@ -52,6 +86,30 @@ type WSMessage struct {
 	Event   string          `json:"event"`   // 事件类型
 	Payload json.RawMessage `json:"payload"` // 消息内容
 	Seq     int64           `json:"seq"`     // 序列号
+	ID      int64           `json:"id,omitempty"` // 关联请求 ID, 服务端在响应中原样带回; 与推送序列号相互独立
+}
+
+// WSError 服务端下发的错误帧
+type WSError struct {
+	Code    int             `json:"code"`    // 错误码
+	Message string          `json:"message"` // 错误信息
+	Raw     json.RawMessage `json:"-"`       // 原始消息
+}
+
+func (e *WSError) Error() string {
+	return fmt.Sprintf("服务端错误 %d: %s", e.Code, e.Message)
+}
+
+// ParseWSError 解析错误帧 (Event 为 EventError) 的 payload。解析失败时
+// 仍返回仅带 Raw 的 *WSError
+func ParseWSError(payload json.RawMessage) (*WSError, error) {
+	wsErr := &WSError{Raw: payload}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, wsErr); err != nil {
+			return wsErr, fmt.Errorf("解析错误帧失败: %w", err)
+		}
+	}
+	return wsErr, nil
 }
 
 // NewWebSocketClient 创建 WebSocket 客户端
@@ -62,6 +120,21 @@ func NewWebSocketClient(config *WebSocketConfig) *WebSocketClient {
 	if config.ReconnectDelay == 0 {
 		config.ReconnectDelay = 5 * time.Second
 	}
+	if config.ReconnectMaxDelay == 0 {
+		config.ReconnectMaxDelay = 60 * time.Second
+	}
+	if config.ReconnectMaxDelay < config.ReconnectDelay {
+		config.ReconnectMaxDelay = config.ReconnectDelay
+	}
+	if config.ReconnectBackoffFactor < 1 {
+		config.ReconnectBackoffFactor = 2
+	}
+	if config.WriteBufferSize <= 0 {
+		config.WriteBufferSize = 100
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = 5 * time.Second
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	return &WebSocketClient{
@@ -71,22 +144,29 @@ func NewWebSocketClient(config *WebSocketConfig) *WebSocketClient {
 		cancel:        cancel,
 		subscriptions: make(map[string]bool),
 		readChan:      make(chan *WSMessage, 100),
-		writeChan:     make(chan []byte, 100),
+		writeChan:     make(chan []byte, config.WriteBufferSize),
 		closeChan:     make(chan struct{}),
+		ready:         make(chan struct{}),
+		waiters:       make(map[int64]chan *WSMessage),
 	}
 }
 
 // Connect 连接到 WebSocket 服务器
 func (c *WebSocketClient) Connect() error {
-	c._mu.Lock()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return fmt.Errorf("客户端已关闭")
+	}
 	if c.isConnected {
-		c._mu.Unlock()
+		c.mu.Unlock()
 		return nil
 	}
-	c._mu.Unlock()
+	c.mu.Unlock()
 
-	// 构建认证 URL
-	url := fmt.Sprintf("%s?token=%s", c.config.Token, c.config.Token)
+	// 构建认证 URL; 每次连接都重新获取 Token, 重连时使用轮换后的 Token
+	token := c.token()
+	url := fmt.Sprintf("%s?token=%s", c.config.URL, token)
 
 	// 设置 WebSocket 握手超时
 	dialer := &websocket.Dialer{
@@ -95,7 +175,7 @@ func (c *WebSocketClient) Connect() error {
 
 	// 添加认证头
 	header := http.Header{}
-	header.Set("Authorization", "Bearer "+c.config.Token)
+	header.Set("Authorization", "Bearer "+token)
 
 	conn, _, err := dialer.Dial(url, header)
 	if err != nil {
@@ -105,16 +185,37 @@ func (c *WebSocketClient) Connect() error {
 		return err
 	}
 
-	c._mu.Lock()
+	c.mu.Lock()
+	if c.closed {
+		// 握手期间调用了 Disconnect
+		c.mu.Unlock()
+		conn.Close()
+		return fmt.Errorf("客户端已关闭")
+	}
+	if c.isConnected {
+		// 并发的 Connect 已先建立连接
+		c.mu.Unlock()
+		conn.Close()
+		return nil
+	}
 	c.conn = conn
 	c.isConnected = true
 	c.isReconnecting = false
-	c._mu.Unlock()
-
-	// 启动读写协程
-	go c.readLoop()
-	go c.writeLoop()
-	go c.heartbeatLoop()
+	c.reading = true
+	c.backoff = 0
+	// 服务端在每个连接上重新编号, 旧连接的序列号不参与跳号检测
+	atomic.StoreInt64(&c.lastSeq, 0)
+	c.mu.Unlock()
+
+	c.readyOnce.Do(func() {
+		close(c.ready)
+	})
+
+	// 启动读写协程; 每个连接有自己的 done 通道, 重连后旧协程随之退出
+	done := make(chan struct{})
+	go c.readLoop(conn, done)
+	go c.writeLoop(conn, done)
+	go c.heartbeatLoop(done)
 
 	// 触发连接成功回调
 	if c.OnConnect != nil {
@@ -127,32 +228,116 @@ func (c *WebSocketClient) Connect() error {
 	return nil
 }
 
+// token 返回当前认证 Token, 配置了 TokenProvider 时以其为准
+func (c *WebSocketClient) token() string {
+	if c.config.TokenProvider != nil {
+		return c.config.TokenProvider()
+	}
+	return c.config.Token
+}
+
+// Ready 返回一个在首次连接成功时关闭的通道
+func (c *WebSocketClient) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Wait 阻塞直到首次连接成功, 或 ctx 结束
+func (c *WebSocketClient) Wait(ctx context.Context) error {
+	select {
+	case <-c.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Disconnect 断开连接
 func (c *WebSocketClient) Disconnect() {
-	c._mu.Lock()
-	defer c._mu.Unlock()
+	// 重复调用时直接返回, 并阻止后续自动重连
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	// 配置了 FlushTimeout 时先尽量把发送队列写完
+	if c.config.FlushTimeout > 0 && c.IsConnected() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.FlushTimeout)
+		c.Flush(ctx)
+		cancel()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	if c.cancel != nil {
 		c.cancel()
 	}
 
 	if c.conn != nil {
+		// 先发送正常关闭帧 (1000), 让服务端记录为正常断开; WriteControl 可与 writeLoop 并发调用
+		closeFrame := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		c.conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(time.Second))
 		c.conn.Close()
 		c.isConnected = false
 	}
 
+	// 没有 readLoop 在运行时直接关闭消息通道, 否则由 readLoop 退出时关闭
+	if !c.reading {
+		c.closeMessages()
+	}
+
 	close(c.closeChan)
 }
 
+// Messages 返回接收消息的只读通道, 可代替 OnMessage 回调用于 select 或 range。
+// 通道缓冲 100 条消息, 缓冲已满时新消息不再写入通道 (OnMessage 仍会收到);
+// Disconnect 后通道在缓冲中的消息读完后关闭, range 随之结束
+func (c *WebSocketClient) Messages() <-chan *WSMessage {
+	return c.readChan
+}
+
+// closeMessages 关闭消息通道, 只执行一次
+func (c *WebSocketClient) closeMessages() {
+	c.readCloseOnce.Do(func() {
+		close(c.readChan)
+	})
+}
+
+// Flush 阻塞直到发送队列中的消息全部写出，或 ctx 结束
+func (c *WebSocketClient) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&c.pending) > 0 {
+		if !c.IsConnected() {
+			return fmt.Errorf("连接已断开, 仍有 %d 条消息未发送", atomic.LoadInt64(&c.pending))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 // Reconnect 重新连接
 func (c *WebSocketClient) Reconnect() {
-	if c.isReconnecting {
+	c.mu.Lock()
+	if c.isReconnecting || c.closed {
+		c.mu.Unlock()
 		return
 	}
-
 	c.isReconnecting = true
+	c.mu.Unlock()
+
 	defer func() {
+		c.mu.Lock()
 		c.isReconnecting = false
+		c.mu.Unlock()
 	}()
 
 	attempts := 0
@@ -171,7 +356,14 @@ func (c *WebSocketClient) Reconnect() {
 			return
 		}
 
-		time.Sleep(c.config.ReconnectDelay)
+		// 等待期间调用 Disconnect 时立即停止
+		timer := time.NewTimer(c.nextReconnectDelay())
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
 
 		if err := c.Connect(); err == nil {
 			return
@@ -179,9 +371,31 @@ func (c *WebSocketClient) Reconnect() {
 	}
 }
 
-// readLoop 读取消息循环
-func (c *WebSocketClient) readLoop() {
+// nextReconnectDelay 返回下一次重连前的等待时间: 从 ReconnectDelay 开始,
+// 每次失败按 ReconnectBackoffFactor 增长, 不超过 ReconnectMaxDelay,
+// 并在 [d/2, d] 内随机抖动以避免服务重启后大量客户端同时重连
+func (c *WebSocketClient) nextReconnectDelay() time.Duration {
+	c.mu.Lock()
+	if c.backoff == 0 {
+		c.backoff = c.config.ReconnectDelay
+	} else {
+		next := time.Duration(float64(c.backoff) * c.config.ReconnectBackoffFactor)
+		if next > c.config.ReconnectMaxDelay || next <= 0 {
+			next = c.config.ReconnectMaxDelay
+		}
+		c.backoff = next
+	}
+	delay := c.backoff
+	c.mu.Unlock()
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// readLoop 读取消息循环, 退出时关闭 done 以停止同一连接的其他协程
+func (c *WebSocketClient) readLoop(conn *websocket.Conn, done chan struct{}) {
 	defer func() {
+		close(done)
 		c.handleDisconnect()
 	}()
 
@@ -192,8 +406,8 @@ func (c *WebSocketClient) readLoop() {
 		default:
 		}
 
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		_, message, err := c.conn.ReadMessage()
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		_, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				if c.OnError != nil {
@@ -216,6 +430,19 @@ func (c *WebSocketClient) readLoop() {
 			continue
 		}
 
+		// 关联请求的响应 (带 ID) 直接交给等待方; 推送消息的序列号不参与匹配
+		if c.deliverResponse(&wsMsg) {
+			continue
+		}
+
+		// 处理服务端错误帧, 与 MessageHandler.dispatch 一样按 Event 字段识别
+		if wsMsg.Event == EventError {
+			c.handleServerError(&wsMsg)
+			continue
+		}
+
+		c.trackSeq(wsMsg.Seq)
+
 		// 发送到消息通道
 		select {
 		case c.readChan <- &wsMsg:
@@ -229,8 +456,161 @@ func (c *WebSocketClient) readLoop() {
 	}
 }
 
+// Send 发送一条应用消息。未连接或发送队列已满时返回错误, 不会静默丢弃
+func (c *WebSocketClient) Send(ctx context.Context, msg *WSMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !c.IsConnected() {
+		return fmt.Errorf("未连接")
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("序列化消息失败: %w", err)
+	}
+	if !c.enqueue(data) {
+		return fmt.Errorf("发送队列已满")
+	}
+	return nil
+}
+
+// Request 发送一条关联请求并等待 ID 相同的响应。msg.ID 由客户端分配;
+// 服务端以错误帧响应时返回 *WSError。ctx 在响应到达前结束时会移除等待方
+// 并向服务端发送取消帧
+func (c *WebSocketClient) Request(ctx context.Context, msg *WSMessage) (*WSMessage, error) {
+	seq := atomic.AddInt64(&c.nextSeq, 1)
+	req := *msg
+	req.ID = seq
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	waiter := make(chan *WSMessage, 1)
+	c.waitersMu.Lock()
+	c.waiters[seq] = waiter
+	c.waitersMu.Unlock()
+
+	if err := c.enqueueWait(ctx, data); err != nil {
+		c.removeWaiter(seq)
+		return nil, err
+	}
+
+	select {
+	case resp := <-waiter:
+		if resp.Event == EventError {
+			wsErr, _ := ParseWSError(resp.Payload)
+			return nil, wsErr
+		}
+		return resp, nil
+	case <-ctx.Done():
+		if c.removeWaiter(seq) {
+			c.sendCancel(seq)
+		}
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		c.removeWaiter(seq)
+		return nil, fmt.Errorf("客户端已关闭")
+	}
+}
+
+// deliverResponse 将响应交给 ID 对应的等待方, 没有等待方时返回 false
+func (c *WebSocketClient) deliverResponse(wsMsg *WSMessage) bool {
+	if wsMsg.ID == 0 {
+		return false
+	}
+
+	c.waitersMu.Lock()
+	waiter, ok := c.waiters[wsMsg.ID]
+	if ok {
+		delete(c.waiters, wsMsg.ID)
+	}
+	c.waitersMu.Unlock()
+
+	if ok {
+		waiter <- wsMsg
+	}
+	return ok
+}
+
+// removeWaiter 移除等待方, 返回其是否仍在等待 (即响应尚未到达)
+func (c *WebSocketClient) removeWaiter(seq int64) bool {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+
+	_, ok := c.waiters[seq]
+	delete(c.waiters, seq)
+	return ok
+}
+
+// sendCancel 通知服务端放弃处理指定 ID 的请求
+func (c *WebSocketClient) sendCancel(seq int64) {
+	data, err := json.Marshal(WSMessage{
+		Type: EventCancel,
+		ID:   seq,
+	})
+	if err != nil {
+		return
+	}
+	if err := c.enqueueTimeout(data); err != nil && c.OnError != nil {
+		c.OnError(fmt.Errorf("发送取消帧失败: %w", err))
+	}
+}
+
+// LastSeq 返回当前连接上最近收到的服务端消息序列号, 尚未收到时为 0。
+// 重连后从 0 重新计数
+func (c *WebSocketClient) LastSeq() int64 {
+	return atomic.LoadInt64(&c.lastSeq)
+}
+
+// trackSeq 记录服务端消息序列号: 跳号时触发 OnGap, 迟到的旧序列号不会回退
+// 记录; 开启 AutoAck 时回复 ack 帧。不带序列号 (0) 的消息不参与检测
+func (c *WebSocketClient) trackSeq(seq int64) {
+	if seq <= 0 {
+		return
+	}
+
+	last := atomic.LoadInt64(&c.lastSeq)
+	if seq > last {
+		atomic.StoreInt64(&c.lastSeq, seq)
+		if last > 0 && seq > last+1 && c.OnGap != nil {
+			c.OnGap(last+1, seq)
+		}
+	}
+
+	if c.config.AutoAck {
+		data, err := json.Marshal(WSMessage{
+			Type: EventAck,
+			Seq:  seq,
+		})
+		if err != nil {
+			return
+		}
+		if err := c.enqueueTimeout(data); err != nil && c.OnError != nil {
+			c.OnError(fmt.Errorf("发送确认帧失败: %w", err))
+		}
+	}
+}
+
+// handleServerError 解析错误帧并触发 OnServerError
+func (c *WebSocketClient) handleServerError(wsMsg *WSMessage) {
+	wsErr, err := ParseWSError(wsMsg.Payload)
+	if err != nil {
+		if c.OnError != nil {
+			c.OnError(err)
+		}
+		return
+	}
+
+	if c.OnServerError != nil {
+		c.OnServerError(wsErr)
+	}
+}
+
 // writeLoop 写入消息循环
-func (c *WebSocketClient) writeLoop() {
+func (c *WebSocketClient) writeLoop(conn *websocket.Conn, done <-chan struct{}) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
@@ -238,15 +618,19 @@ func (c *WebSocketClient) writeLoop() {
 		select {
 		case <-c.ctx.Done():
 			return
+		case <-done:
+			return
 		case message := <-c.writeChan:
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			err := conn.WriteMessage(websocket.TextMessage, message)
+			atomic.AddInt64(&c.pending, -1)
+			if err != nil {
 				if c.OnError != nil {
 					c.OnError(fmt.Errorf("发送消息失败: %w", err))
 				}
 			}
 		case <-ticker.C:
 			// 保持连接活跃
-			if err := c.conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+			if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
 				if c.OnError != nil {
 					c.OnError(fmt.Errorf("发送 ping 失败: %w", err))
 				}
@@ -256,7 +640,7 @@ func (c *WebSocketClient) writeLoop() {
 }
 
 // heartbeatLoop 心跳循环
-func (c *WebSocketClient) heartbeatLoop() {
+func (c *WebSocketClient) heartbeatLoop(done <-chan struct{}) {
 	ticker := time.NewTicker(c.config.HeartbeatInterval)
 	defer ticker.Stop()
 
@@ -264,6 +648,8 @@ func (c *WebSocketClient) heartbeatLoop() {
 		select {
 		case <-c.ctx.Done():
 			return
+		case <-done:
+			return
 		case <-ticker.C:
 			c.sendPing()
 		}
@@ -282,29 +668,96 @@ func (c *WebSocketClient) sendPing() {
 		return
 	}
 
+	if err := c.enqueueTimeout(data); err != nil && c.OnError != nil {
+		c.OnError(fmt.Errorf("发送心跳失败: %w", err))
+	}
+}
+
+// enqueueTimeout 将消息放入发送队列, 队列已满时最多等待 WriteTimeout
+func (c *WebSocketClient) enqueueTimeout(data []byte) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.WriteTimeout)
+	defer cancel()
+	return c.enqueueWait(ctx, data)
+}
+
+// enqueueWait 将消息放入发送队列, 队列已满时阻塞直到有空位、ctx 结束或客户端关闭
+func (c *WebSocketClient) enqueueWait(ctx context.Context, data []byte) error {
+	atomic.AddInt64(&c.pending, 1)
 	select {
 	case c.writeChan <- data:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&c.pending, -1)
+		if c.ctx.Err() != nil {
+			return fmt.Errorf("客户端已关闭")
+		}
+		return fmt.Errorf("发送队列已满: %w", ctx.Err())
+	}
+}
+
+// enqueue 将消息放入发送队列, 队列已满时返回 false
+func (c *WebSocketClient) enqueue(data []byte) bool {
+	atomic.AddInt64(&c.pending, 1)
+	select {
+	case c.writeChan <- data:
+		return true
 	default:
+		atomic.AddInt64(&c.pending, -1)
+		return false
 	}
 }
 
 // handleDisconnect 处理断线
 func (c *WebSocketClient) handleDisconnect() {
-	c._mu.Lock()
+	c.mu.Lock()
 	wasConnected := c.isConnected
 	c.isConnected = false
-	c._mu.Unlock()
+	c.reading = false
+	closed := c.closed
+	if closed {
+		// readLoop 已退出, 不会再写入消息通道
+		c.closeMessages()
+	}
+	c.mu.Unlock()
 
 	if wasConnected && c.OnDisconnect != nil {
 		c.OnDisconnect(fmt.Errorf("连接已断开"))
 	}
 
-	// 自动重连
+	// 主动断开后不再自动重连
+	if closed {
+		return
+	}
 	go c.Reconnect()
 }
 
-// Subscribe 订阅消息
+// subscribableEvents 可通过 Subscribe 订阅的内置事件
+var subscribableEvents = map[string]bool{
+	EventUserMessage:    true,
+	EventCardCallback:   true,
+	EventApprovalChange: true,
+	EventReaction:       true,
+}
+
+// Subscribe 订阅内置事件（Event* 常量），未知事件名返回错误以避免拼写错误
+// 导致订阅静默失效；自定义事件请使用 SubscribeCustom
 func (c *WebSocketClient) Subscribe(event string) error {
+	if !subscribableEvents[event] {
+		return fmt.Errorf("未知的订阅事件: %q（自定义事件请使用 SubscribeCustom）", event)
+	}
+	return c.subscribe(event)
+}
+
+// SubscribeCustom 订阅自定义事件，不做事件名校验
+func (c *WebSocketClient) SubscribeCustom(event string) error {
+	if event == "" {
+		return fmt.Errorf("事件名不能为空")
+	}
+	return c.subscribe(event)
+}
+
+// subscribe 发送订阅请求并记录订阅
+func (c *WebSocketClient) subscribe(event string) error {
 	c.subMu.Lock()
 	defer c.subMu.Unlock()
 
@@ -322,13 +775,11 @@ func (c *WebSocketClient) Subscribe(event string) error {
 		return err
 	}
 
-	select {
-	case c.writeChan <- data:
-		c.subscriptions[event] = true
-		return nil
-	default:
-		return fmt.Errorf("发送通道已满")
+	if err := c.enqueueTimeout(data); err != nil {
+		return err
 	}
+	c.subscriptions[event] = true
+	return nil
 }
 
 // Unsubscribe 取消订阅
@@ -350,13 +801,11 @@ func (c *WebSocketClient) Unsubscribe(event string) error {
 		return err
 	}
 
-	select {
-	case c.writeChan <- data:
-		delete(c.subscriptions, event)
-		return nil
-	default:
-		return fmt.Errorf("发送通道已满")
+	if err := c.enqueueTimeout(data); err != nil {
+		return err
 	}
+	delete(c.subscriptions, event)
+	return nil
 }
 
 // resubscribe 重新订阅
@@ -374,17 +823,27 @@ func (c *WebSocketClient) resubscribe() {
 			Event: event,
 		}
 		data, _ := json.Marshal(subscribeMsg)
-		select {
-		case c.writeChan <- data:
-		default:
+
+		// 队列已满时持续重试; 连接断开后由下次连接重新订阅
+		for {
+			err := c.enqueueTimeout(data)
+			if err == nil {
+				break
+			}
+			if !c.IsConnected() {
+				return
+			}
+			if c.OnError != nil {
+				c.OnError(fmt.Errorf("重新订阅 %s 失败, 重试中: %w", event, err))
+			}
 		}
 	}
 }
 
 // IsConnected 检查是否已连接
 func (c *WebSocketClient) IsConnected() bool {
-	c._mu.RLock()
-	defer c._mu.RUnlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.isConnected
 }
 
@@ -0,0 +1,1029 @@
+package taibai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestWSServer starts a WebSocket server that records every text frame it
+// receives and sends the full list on the returned channel once the
+// connection is closed by the client.
+func newTestWSServer(t *testing.T) (*httptest.Server, <-chan [][]byte) {
+	t.Helper()
+
+	received := make(chan [][]byte, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var frames [][]byte
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			frames = append(frames, data)
+		}
+		received <- frames
+	}))
+	t.Cleanup(server.Close)
+
+	return server, received
+}
+
+// newPushWSServer starts a WebSocket server that pushes the given frames to
+// every client right after the handshake and then waits for it to close.
+func newPushWSServer(t *testing.T, frames ...string) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for _, frame := range frames {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				return
+			}
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestWebSocketFlush(t *testing.T) {
+	server, received := newTestWSServer(t)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+
+	events := []string{EventUserMessage, EventCardCallback, EventApprovalChange}
+	for _, event := range events {
+		if err := client.Subscribe(event); err != nil {
+			t.Fatalf("Expected no error on Subscribe, got %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Flush(ctx); err != nil {
+		t.Fatalf("Expected no error on Flush, got %v", err)
+	}
+
+	client.Disconnect()
+
+	select {
+	case frames := <-received:
+		if len(frames) != len(events) {
+			t.Fatalf("Expected %d frames, got %d", len(events), len(frames))
+		}
+		for i, frame := range frames {
+			var req WSSubscribeRequest
+			if err := json.Unmarshal(frame, &req); err != nil {
+				t.Fatalf("Failed to unmarshal frame: %v", err)
+			}
+			if req.Event != events[i] {
+				t.Errorf("Expected event '%s', got '%s'", events[i], req.Event)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for server to receive frames")
+	}
+}
+
+func TestWebSocketFlushNotConnected(t *testing.T) {
+	client := NewWebSocketClient(&WebSocketConfig{URL: "ws://127.0.0.1:0"})
+
+	// Nothing queued: Flush returns immediately
+	if err := client.Flush(context.Background()); err != nil {
+		t.Errorf("Expected no error on empty queue, got %v", err)
+	}
+
+	// Queued but never connected: Flush reports the pending messages
+	client.Subscribe(EventUserMessage)
+	if err := client.Flush(context.Background()); err == nil {
+		t.Error("Expected error when flushing a disconnected client")
+	}
+}
+
+func TestWebSocketDisconnectFlushTimeout(t *testing.T) {
+	server, received := newTestWSServer(t)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:          wsURL(server),
+		Token:        "test-token",
+		FlushTimeout: 2 * time.Second,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+
+	client.Subscribe(EventUserMessage)
+	client.Subscribe(EventCardCallback)
+	client.Disconnect()
+
+	select {
+	case frames := <-received:
+		if len(frames) != 2 {
+			t.Errorf("Expected 2 frames, got %d", len(frames))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for server to receive frames")
+	}
+}
+
+func TestWebSocketServerErrorFrame(t *testing.T) {
+	server := newPushWSServer(t,
+		`{"type":"event","event":"error","payload":{"code":4001,"message":"invalid subscription"}}`,
+	)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+
+	errs := make(chan *WSError, 1)
+	client.OnServerError = func(e *WSError) {
+		errs <- e
+	}
+	client.OnMessage = func(msg *WSMessage) {
+		t.Errorf("Expected error frame not to reach OnMessage, got %+v", msg)
+	}
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	defer client.Disconnect()
+
+	select {
+	case e := <-errs:
+		if e.Code != 4001 {
+			t.Errorf("Expected code 4001, got %d", e.Code)
+		}
+		if e.Message != "invalid subscription" {
+			t.Errorf("Expected message 'invalid subscription', got '%s'", e.Message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for OnServerError")
+	}
+}
+
+func TestWebSocketWaitReady(t *testing.T) {
+	server, _ := newTestWSServer(t)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	defer client.Disconnect()
+
+	select {
+	case <-client.Ready():
+		t.Fatal("Expected Ready to block before connecting")
+	default:
+	}
+
+	go client.Connect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Wait(ctx); err != nil {
+		t.Fatalf("Expected Wait to return after connecting, got %v", err)
+	}
+}
+
+func TestWebSocketWaitUnreachable(t *testing.T) {
+	// Grab a free port and close it so nothing is listening
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := wsURL(server)
+	server.Close()
+
+	client := NewWebSocketClient(&WebSocketConfig{URL: url})
+	go client.Connect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestWebSocketSubscribeValidation(t *testing.T) {
+	client := NewWebSocketClient(&WebSocketConfig{URL: "ws://127.0.0.1:0"})
+
+	for _, event := range []string{EventUserMessage, EventReaction} {
+		if err := client.Subscribe(event); err != nil {
+			t.Errorf("Expected no error for built-in event %s, got %v", event, err)
+		}
+		if !client.subscriptions[event] {
+			t.Errorf("Expected %s to be recorded", event)
+		}
+	}
+
+	if err := client.Subscribe("user_messages"); err == nil {
+		t.Error("Expected error for misspelled event")
+	}
+	if client.subscriptions["user_messages"] {
+		t.Error("Expected rejected event not to be recorded")
+	}
+
+	if err := client.SubscribeCustom("x_custom_event"); err != nil {
+		t.Errorf("Expected no error for custom event, got %v", err)
+	}
+	if !client.subscriptions["x_custom_event"] {
+		t.Error("Expected custom event to be recorded")
+	}
+}
+
+func TestWebSocketRequest(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var msg WSMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			// A push whose seq collides with the request ID must not be
+			// taken as the response
+			conn.WriteJSON(WSMessage{
+				Type:  "event",
+				Event: EventUserMessage,
+				Seq:   msg.ID,
+			})
+			conn.WriteJSON(WSMessage{
+				Type:    "response",
+				ID:      msg.ID,
+				Payload: json.RawMessage(`{"ok":true}`),
+			})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	pushes := make(chan *WSMessage, 1)
+	client.OnMessage = func(msg *WSMessage) {
+		if msg.Type == "response" {
+			t.Errorf("Expected response not to reach OnMessage, got %+v", msg)
+			return
+		}
+		pushes <- msg
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	defer client.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := client.Request(ctx, &WSMessage{Type: "rpc", Event: "echo"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if resp.Type != "response" {
+		t.Errorf("Expected type 'response', got '%s'", resp.Type)
+	}
+	if string(resp.Payload) != `{"ok":true}` {
+		t.Errorf("Expected payload '{\"ok\":true}', got '%s'", resp.Payload)
+	}
+
+	select {
+	case push := <-pushes:
+		if push.Seq != 1 {
+			t.Errorf("Expected push with seq 1, got %d", push.Seq)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the colliding push to reach OnMessage")
+	}
+	if client.LastSeq() != 1 {
+		t.Errorf("Expected the push seq to be tracked, got %d", client.LastSeq())
+	}
+}
+
+func TestWebSocketRequestCancel(t *testing.T) {
+	server, received := newTestWSServer(t)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+
+	before := runtime.NumGoroutine()
+
+	const requests = 20
+	for i := 0; i < requests; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := client.Request(ctx, &WSMessage{Type: "rpc", Event: "slow"})
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Expected DeadlineExceeded, got %v", err)
+		}
+	}
+
+	client.waitersMu.Lock()
+	pending := len(client.waiters)
+	client.waitersMu.Unlock()
+	if pending != 0 {
+		t.Errorf("Expected no pending waiters, got %d", pending)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected no leaked goroutines, had %d before and %d after", before, after)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Flush(flushCtx); err != nil {
+		t.Fatalf("Expected no error on Flush, got %v", err)
+	}
+	client.Disconnect()
+
+	select {
+	case frames := <-received:
+		if len(frames) != 2*requests {
+			t.Fatalf("Expected %d frames, got %d", 2*requests, len(frames))
+		}
+		for i := 0; i < len(frames); i += 2 {
+			var req, cancelFrame WSMessage
+			json.Unmarshal(frames[i], &req)
+			json.Unmarshal(frames[i+1], &cancelFrame)
+			if cancelFrame.Type != EventCancel {
+				t.Errorf("Expected type '%s', got '%s'", EventCancel, cancelFrame.Type)
+			}
+			if req.ID == 0 || cancelFrame.ID != req.ID {
+				t.Errorf("Expected cancel ID %d, got %d", req.ID, cancelFrame.ID)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for server to receive frames")
+	}
+}
+
+func TestWebSocketDisconnectTwice(t *testing.T) {
+	server, _ := newTestWSServer(t)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Expected repeated Disconnect not to panic, got %v", r)
+		}
+	}()
+	client.Disconnect()
+	client.Disconnect()
+
+	if client.IsConnected() {
+		t.Error("Expected client to be disconnected")
+	}
+	if err := client.Connect(); err == nil {
+		t.Error("Expected Connect to fail after Disconnect")
+	}
+}
+
+func TestWebSocketDisconnectCloseCode(t *testing.T) {
+	codes := make(chan int, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				code := -1
+				if closeErr, ok := err.(*websocket.CloseError); ok {
+					code = closeErr.Code
+				}
+				codes <- code
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	client.Disconnect()
+
+	select {
+	case code := <-codes:
+		if code != websocket.CloseNormalClosure {
+			t.Errorf("Expected close code %d, got %d", websocket.CloseNormalClosure, code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the server to see the close")
+	}
+}
+
+func TestWebSocketDisconnectStopsReconnect(t *testing.T) {
+	var connections int32
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		// Drop the connection right away to trigger auto-reconnect
+		atomic.AddInt32(&connections, 1)
+		conn.Close()
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:            wsURL(server),
+		Token:          "test-token",
+		ReconnectDelay: 50 * time.Millisecond,
+	})
+	dropped := make(chan struct{}, 1)
+	client.OnDisconnect = func(error) {
+		select {
+		case dropped <- struct{}{}:
+		default:
+		}
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+
+	select {
+	case <-dropped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the connection to drop")
+	}
+	client.Disconnect()
+
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("Expected no reconnect after Disconnect, got %d connections", n)
+	}
+}
+
+func TestWebSocketTokenProviderReconnect(t *testing.T) {
+	type connection struct {
+		token  string
+		frames []string
+	}
+	connections := make(chan connection, 4)
+	drop := make(chan struct{})
+	var first int32
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Record the subscribe frame sent on this connection; the first
+		// connection is dropped once the test has rotated the token
+		got := connection{token: r.URL.Query().Get("token")}
+		_, data, err := conn.ReadMessage()
+		if err == nil {
+			got.frames = append(got.frames, string(data))
+		}
+		connections <- got
+		if atomic.AddInt32(&first, 1) == 1 {
+			<-drop
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	var mu sync.Mutex
+	token := "token-1"
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:            wsURL(server),
+		ReconnectDelay: 10 * time.Millisecond,
+		TokenProvider: func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			return token
+		},
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	defer client.Disconnect()
+	if err := client.Subscribe(EventUserMessage); err != nil {
+		t.Fatalf("Expected no error on Subscribe, got %v", err)
+	}
+
+	wait := func() connection {
+		select {
+		case got := <-connections:
+			return got
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a connection")
+		}
+		return connection{}
+	}
+
+	if got := wait(); got.token != "token-1" {
+		t.Errorf("Expected first connection with 'token-1', got '%s'", got.token)
+	}
+
+	mu.Lock()
+	token = "token-2"
+	mu.Unlock()
+	close(drop)
+
+	got := wait()
+	if got.token != "token-2" {
+		t.Errorf("Expected reconnect with rotated 'token-2', got '%s'", got.token)
+	}
+	if len(got.frames) != 1 || !strings.Contains(got.frames[0], `"event":"user_message"`) {
+		t.Errorf("Expected subscription to be restored, got %v", got.frames)
+	}
+}
+
+// TestWebSocketConcurrentLifecycle is meant to run under -race: it connects,
+// reads, writes and disconnects from several goroutines at once
+func TestWebSocketConcurrentLifecycle(t *testing.T) {
+	frames := make([]string, 50)
+	for i := range frames {
+		frames[i] = `{"type":"event","event":"user_message","payload":{}}`
+	}
+	server := newPushWSServer(t, frames...)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:               wsURL(server),
+		Token:             "test-token",
+		HeartbeatInterval: time.Millisecond,
+		ReconnectDelay:    time.Millisecond,
+	})
+	var received int32
+	client.OnMessage = func(msg *WSMessage) {
+		atomic.AddInt32(&received, 1)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Connect()
+		}()
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Wait(ctx); err != nil {
+		t.Fatalf("Expected client to connect, got %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				client.IsConnected()
+				client.SubscribeCustom("custom_event")
+				client.Reconnect()
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client.Disconnect()
+	}()
+	wg.Wait()
+
+	if client.IsConnected() {
+		t.Error("Expected client to be disconnected")
+	}
+	if atomic.LoadInt32(&received) == 0 {
+		t.Error("Expected pushed messages to be read")
+	}
+}
+
+func TestWebSocketReconnectBackoff(t *testing.T) {
+	server, _ := newTestWSServer(t)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:                    wsURL(server),
+		Token:                  "test-token",
+		ReconnectDelay:         100 * time.Millisecond,
+		ReconnectMaxDelay:      500 * time.Millisecond,
+		ReconnectBackoffFactor: 2,
+	})
+	defer client.Disconnect()
+
+	// Failed attempts: the backoff doubles up to the cap
+	expected := []time.Duration{100, 200, 400, 500, 500}
+	for i, want := range expected {
+		want *= time.Millisecond
+		delay := client.nextReconnectDelay()
+		if delay < want/2 || delay > want {
+			t.Errorf("Attempt %d: expected delay in [%v, %v], got %v", i+1, want/2, want, delay)
+		}
+	}
+
+	// A successful connection resets the backoff to the base delay
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	if delay := client.nextReconnectDelay(); delay < 50*time.Millisecond || delay > 100*time.Millisecond {
+		t.Errorf("Expected delay reset to [50ms, 100ms], got %v", delay)
+	}
+}
+
+func TestWebSocketSend(t *testing.T) {
+	server, received := newTestWSServer(t)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+
+	msg := &WSMessage{
+		Type:    MessageTypeText,
+		Event:   EventUserMessage,
+		Payload: json.RawMessage(`{"text":"你好","count":2}`),
+		Seq:     7,
+	}
+	if err := client.Send(context.Background(), msg); err == nil {
+		t.Error("Expected error when sending before Connect")
+	}
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	if err := client.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Expected no error on Send, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Flush(ctx); err != nil {
+		t.Fatalf("Expected no error on Flush, got %v", err)
+	}
+	client.Disconnect()
+
+	select {
+	case frames := <-received:
+		if len(frames) != 1 {
+			t.Fatalf("Expected 1 frame, got %d", len(frames))
+		}
+		var got WSMessage
+		if err := json.Unmarshal(frames[0], &got); err != nil {
+			t.Fatalf("Failed to unmarshal frame: %v", err)
+		}
+		if got.Type != msg.Type || got.Event != msg.Event || got.Seq != msg.Seq {
+			t.Errorf("Expected %+v, got %+v", msg, got)
+		}
+		if string(got.Payload) != string(msg.Payload) {
+			t.Errorf("Expected payload %s, got %s", msg.Payload, got.Payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for server to receive frames")
+	}
+}
+
+func TestWebSocketSendQueueFull(t *testing.T) {
+	client := NewWebSocketClient(&WebSocketConfig{URL: "ws://127.0.0.1:0"})
+	// Pretend to be connected without a writer draining the queue
+	client.mu.Lock()
+	client.isConnected = true
+	client.mu.Unlock()
+
+	var err error
+	for i := 0; i <= cap(client.writeChan); i++ {
+		if err = client.Send(context.Background(), &WSMessage{Type: MessageTypeText}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Error("Expected error once the send queue is full")
+	}
+}
+
+func TestWebSocketSubscribeBackpressure(t *testing.T) {
+	server, received := newTestWSServer(t)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:             wsURL(server),
+		Token:           "test-token",
+		WriteBufferSize: 2,
+		WriteTimeout:    2 * time.Second,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+
+	const events = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, events)
+	for i := 0; i < events; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- client.SubscribeCustom("custom_" + strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Expected no error on Subscribe, got %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Flush(ctx); err != nil {
+		t.Fatalf("Expected no error on Flush, got %v", err)
+	}
+	client.Disconnect()
+
+	select {
+	case frames := <-received:
+		seen := make(map[string]bool)
+		for _, frame := range frames {
+			var req WSSubscribeRequest
+			if err := json.Unmarshal(frame, &req); err == nil && req.Type == "subscribe" {
+				seen[req.Event] = true
+			}
+		}
+		if len(seen) != events {
+			t.Errorf("Expected %d subscriptions to reach the server, got %d", events, len(seen))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for server to receive frames")
+	}
+}
+
+func TestWebSocketSubscribeTimeout(t *testing.T) {
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:             "ws://127.0.0.1:0",
+		WriteBufferSize: 1,
+		WriteTimeout:    20 * time.Millisecond,
+	})
+
+	// Nothing drains the queue: the second subscription must fail, not vanish
+	if err := client.SubscribeCustom("first"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := client.SubscribeCustom("second"); err == nil {
+		t.Error("Expected error when the send queue stays full")
+	}
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	server := newPushWSServer(t,
+		`{"type":"event","event":"user_message","payload":{"text":"first"},"seq":1}`,
+		`{"type":"event","event":"user_message","payload":{"text":"second"},"seq":2}`,
+	)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+
+	for _, want := range []string{`{"text":"first"}`, `{"text":"second"}`} {
+		select {
+		case msg := <-client.Messages():
+			if string(msg.Payload) != want {
+				t.Errorf("Expected payload %s, got %s", want, msg.Payload)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a message")
+		}
+	}
+
+	// The channel closes after Disconnect so range loops terminate
+	client.Disconnect()
+	done := make(chan struct{})
+	go func() {
+		for range client.Messages() {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Messages to be closed after Disconnect")
+	}
+}
+
+func TestWebSocketMessagesClosedWithoutConnect(t *testing.T) {
+	client := NewWebSocketClient(&WebSocketConfig{URL: "ws://127.0.0.1:0"})
+	client.Disconnect()
+
+	select {
+	case _, ok := <-client.Messages():
+		if ok {
+			t.Error("Expected no messages")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Messages to be closed after Disconnect")
+	}
+}
+
+func TestWebSocketSeqGap(t *testing.T) {
+	var frames []string
+	for _, seq := range []int{1, 2, 5, 3, 6, 9} {
+		frames = append(frames, `{"type":"event","event":"user_message","seq":`+strconv.Itoa(seq)+`}`)
+	}
+	server := newPushWSServer(t, frames...)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:   wsURL(server),
+		Token: "test-token",
+	})
+	type gap struct{ expected, got int64 }
+	gaps := make(chan gap, 10)
+	client.OnGap = func(expected, got int64) {
+		gaps <- gap{expected, got}
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	defer client.Disconnect()
+
+	for _, want := range []gap{{3, 5}, {7, 9}} {
+		select {
+		case got := <-gaps:
+			if got != want {
+				t.Errorf("Expected gap %+v, got %+v", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for gap %+v", want)
+		}
+	}
+	select {
+	case extra := <-gaps:
+		t.Errorf("Expected no further gaps, got %+v", extra)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if client.LastSeq() != 9 {
+		t.Errorf("Expected last seq 9, got %d", client.LastSeq())
+	}
+}
+
+func TestWebSocketSeqResetOnReconnect(t *testing.T) {
+	var connections int32
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// The first connection ends after seq 5 and 6; the server numbers
+		// the next connection from 1 again
+		if atomic.AddInt32(&connections, 1) == 1 {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"event","event":"user_message","seq":5}`))
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"event","event":"user_message","seq":6}`))
+			time.Sleep(50 * time.Millisecond)
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"event","event":"user_message","seq":1}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"event","event":"user_message","seq":2}`))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:            wsURL(server),
+		Token:          "test-token",
+		ReconnectDelay: 10 * time.Millisecond,
+	})
+	client.OnGap = func(expected, got int64) {
+		t.Errorf("Expected no gap across reconnects, got expected %d, got %d", expected, got)
+	}
+	seqs := make(chan int64, 10)
+	client.OnMessage = func(msg *WSMessage) {
+		seqs <- msg.Seq
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	defer client.Disconnect()
+
+	for _, want := range []int64{5, 6, 1, 2} {
+		select {
+		case got := <-seqs:
+			if got != want {
+				t.Errorf("Expected seq %d, got %d", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for seq %d", want)
+		}
+	}
+	if client.LastSeq() != 2 {
+		t.Errorf("Expected last seq 2 after reconnect, got %d", client.LastSeq())
+	}
+}
+
+func TestWebSocketAutoAck(t *testing.T) {
+	acks := make(chan int64, 10)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"event","event":"user_message","seq":1}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"event","event":"user_message","seq":2}`))
+		for {
+			var msg WSMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == EventAck {
+				acks <- msg.Seq
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewWebSocketClient(&WebSocketConfig{
+		URL:     wsURL(server),
+		Token:   "test-token",
+		AutoAck: true,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected no error on Connect, got %v", err)
+	}
+	defer client.Disconnect()
+
+	for _, want := range []int64{1, 2} {
+		select {
+		case got := <-acks:
+			if got != want {
+				t.Errorf("Expected ack for seq %d, got %d", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for ack %d", want)
+		}
+	}
+}